@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"crypto/subtle"
 	"sync"
 
 	"github.com/256dpi/gomqtt/packet"
@@ -65,6 +66,10 @@ type Backend interface {
 	// Authenticate should authenticate the client using the user and password
 	// values and return true if the client is eligible to continue or false
 	// when the broker should terminate the connection.
+	//
+	// Note: If true is returned, the connection is accepted and Terminate is
+	// guaranteed to be called once the client goes offline. Resources that are
+	// allocated for the client can therefore be released in Terminate.
 	Authenticate(client *Client, user, password string) (bool, error)
 
 	// Setup is called when a new client comes online and is successfully
@@ -85,7 +90,8 @@ type Backend interface {
 	QueueOffline(*Client) error
 
 	// Subscribe should subscribe the passed client to the specified topic and
-	// call Publish with any incoming messages.
+	// call Publish with any incoming messages. ErrSubscriptionDenied can be
+	// returned to reject the subscription without closing the client.
 	Subscribe(*Client, *packet.Subscription) error
 
 	// Unsubscribe should unsubscribe the passed client from the specified topic.
@@ -116,10 +122,20 @@ type Backend interface {
 	Terminate(*Client) error
 }
 
+// A CredentialStore verifies the credentials of clients.
+type CredentialStore interface {
+	// Verify should return true if the password is valid for the user.
+	Verify(user, password string) (bool, error)
+}
+
 // A MemoryBackend stores everything in memory.
 type MemoryBackend struct {
+	// Credentials holds plain text passwords per user.
 	Credentials map[string]string
 
+	// CredentialStore is used instead of Credentials if set.
+	CredentialStore CredentialStore
+
 	subscribedClients    *topic.Tree
 	retainedMessages     *topic.Tree
 	storedSessions       sync.Map
@@ -139,18 +155,23 @@ func NewMemoryBackend() *MemoryBackend {
 	}
 }
 
-// Authenticate authenticates a clients credentials by matching them to the
-// saved Credentials map.
+// Authenticate authenticates a clients credentials by verifying them with the
+// CredentialStore if set or matching them to the saved Credentials map.
 func (m *MemoryBackend) Authenticate(client *Client, user, password string) (bool, error) {
 	// mutex locking not needed
 
+	// verify with credential store if available
+	if m.CredentialStore != nil {
+		return m.CredentialStore.Verify(user, password)
+	}
+
 	// allow all if there are no credentials
 	if m.Credentials == nil {
 		return true, nil
 	}
 
 	// check login
-	if pw, ok := m.Credentials[user]; ok && pw == password {
+	if pw, ok := m.Credentials[user]; ok && subtle.ConstantTimeCompare([]byte(pw), []byte(password)) == 1 {
 		return true, nil
 	}
 
@@ -0,0 +1,135 @@
+package broker
+
+import (
+	"crypto/x509"
+)
+
+// A CertificateIdentity is a function that derives an identity from a verified
+// client certificate. It should return an empty string if the certificate does
+// not carry a usable identity.
+type CertificateIdentity func(*x509.Certificate) string
+
+// CommonNameIdentity returns the subject common name of the certificate.
+func CommonNameIdentity(cert *x509.Certificate) string {
+	return cert.Subject.CommonName
+}
+
+// DNSNameIdentity returns the first DNS name of the certificate's subject
+// alternative names.
+func DNSNameIdentity(cert *x509.Certificate) string {
+	if len(cert.DNSNames) == 0 {
+		return ""
+	}
+
+	return cert.DNSNames[0]
+}
+
+// EmailAddressIdentity returns the first email address of the certificate's
+// subject alternative names.
+func EmailAddressIdentity(cert *x509.Certificate) string {
+	if len(cert.EmailAddresses) == 0 {
+		return ""
+	}
+
+	return cert.EmailAddresses[0]
+}
+
+// URIIdentity returns the first URI of the certificate's subject alternative
+// names.
+func URIIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) == 0 {
+		return ""
+	}
+
+	return cert.URIs[0].String()
+}
+
+// A CertificateAuthenticator wraps a Backend and authenticates clients that
+// present a verified TLS client certificate. Clients without a certificate are
+// authenticated by the wrapped Backend unless a certificate is required.
+//
+// Note: The tls.Config of the server must request client certificates using
+// ClientAuth and provide the certificate authorities using ClientCAs.
+type CertificateAuthenticator struct {
+	Backend
+
+	// Identity is used to derive the identity from the certificate. The
+	// subject common name is used by default.
+	Identity CertificateIdentity
+
+	// RequireCertificate will deny clients that did not present a verified
+	// client certificate.
+	RequireCertificate bool
+
+	// MapUsername will replace the username of the client with the identity.
+	MapUsername bool
+
+	// MapClientID will replace the client id of the client with the identity.
+	MapClientID bool
+
+	// EnforceClientID will deny clients that supplied a client id that does
+	// not match the identity.
+	EnforceClientID bool
+}
+
+// NewCertificateAuthenticator returns a new CertificateAuthenticator that
+// wraps the specified Backend.
+func NewCertificateAuthenticator(backend Backend) *CertificateAuthenticator {
+	return &CertificateAuthenticator{
+		Backend:  backend,
+		Identity: CommonNameIdentity,
+	}
+}
+
+// Authenticate will authenticate the client using its verified certificate. If
+// the client did not present a certificate, the call is forwarded to the
+// wrapped Backend.
+func (a *CertificateAuthenticator) Authenticate(client *Client, user, password string) (bool, error) {
+	// get certificate
+	cert := verifiedCertificate(client)
+	if cert == nil {
+		// deny if a certificate is required
+		if a.RequireCertificate {
+			return false, nil
+		}
+
+		return a.Backend.Authenticate(client, user, password)
+	}
+
+	// get identity
+	identity := CommonNameIdentity(cert)
+	if a.Identity != nil {
+		identity = a.Identity(cert)
+	}
+
+	// deny if identity is missing
+	if identity == "" {
+		return false, nil
+	}
+
+	// check client id
+	if a.EnforceClientID && client.clientID != identity {
+		return false, nil
+	}
+
+	// map identity
+	if a.MapUsername {
+		client.username = identity
+	}
+	if a.MapClientID {
+		client.clientID = identity
+	}
+
+	return true, nil
+}
+
+// returns the verified leaf certificate of the client if available
+func verifiedCertificate(client *Client) *x509.Certificate {
+	// get state
+	state, ok := client.TLSConnectionState()
+	if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
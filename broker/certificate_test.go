@@ -0,0 +1,253 @@
+package broker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPKI struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI() *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return &testPKI{
+		ca:     ca,
+		caKey:  key,
+		pool:   pool,
+		serial: 1,
+	}
+}
+
+func (p *testPKI) issue(template *x509.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	p.serial++
+	template.SerialNumber = big.NewInt(p.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		panic(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func runCertificateEngine(backend Backend, pki *testPKI, logger Logger) (string, chan struct{}, chan struct{}) {
+	engine := NewEngineWithBackend(backend)
+	engine.Logger = logger
+
+	server, err := transport.NewSecureNetServer("localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "localhost"},
+			DNSNames:    []string{"localhost"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pki.pool,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	engine.Accept(server)
+
+	go func() {
+		<-quit
+		server.Close()
+		engine.Close()
+		engine.Wait(10 * time.Millisecond)
+		close(done)
+	}()
+
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+
+	return port, quit, done
+}
+
+func certificateConfig(port, id string, pki *testPKI, cert *tls.Certificate) *client.Config {
+	dialer := transport.NewDialer()
+	dialer.TLSConfig = &tls.Config{
+		RootCAs:    pki.pool,
+		ServerName: "localhost",
+	}
+
+	if cert != nil {
+		dialer.TLSConfig.Certificates = []tls.Certificate{*cert}
+	}
+
+	config := client.NewConfigWithClientID("tls://localhost:"+port, id)
+	config.Dialer = dialer
+
+	return config
+}
+
+func certificateConnect(t *testing.T, config *client.Config) packet.ConnackCode {
+	c := client.New()
+
+	cf, err := c.Connect(config)
+	require.NoError(t, err)
+
+	cf.Wait(10 * time.Second)
+	code := cf.ReturnCode()
+
+	if code == packet.ConnectionAccepted {
+		assert.NoError(t, c.Disconnect())
+	}
+
+	return code
+}
+
+func TestCertificateAuthenticator(t *testing.T) {
+	pki := newTestPKI()
+
+	backend := NewMemoryBackend()
+	backend.Credentials = map[string]string{}
+
+	auth := NewCertificateAuthenticator(backend)
+	auth.MapUsername = true
+
+	usernames := make(chan string, 1)
+	port, quit, done := runCertificateEngine(auth, pki, func(event LogEvent, c *Client, pkt packet.GenericPacket, _ *packet.Message, _ error) {
+		if _, ok := pkt.(*packet.ConnackPacket); ok && event == PacketSent && c.Username() != "" {
+			usernames <- c.Username()
+		}
+	})
+
+	cert := pki.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "device1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	code := certificateConnect(t, certificateConfig(port, "", pki, &cert))
+	assert.Equal(t, packet.ConnectionAccepted, code)
+	assert.Equal(t, "device1", <-usernames)
+
+	code = certificateConnect(t, certificateConfig(port, "", pki, nil))
+	assert.Equal(t, packet.ErrNotAuthorized, code)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestCertificateAuthenticatorRequireCertificate(t *testing.T) {
+	pki := newTestPKI()
+
+	auth := NewCertificateAuthenticator(NewMemoryBackend())
+	auth.RequireCertificate = true
+
+	port, quit, done := runCertificateEngine(auth, pki, nil)
+
+	code := certificateConnect(t, certificateConfig(port, "", pki, nil))
+	assert.Equal(t, packet.ErrNotAuthorized, code)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestCertificateAuthenticatorClientID(t *testing.T) {
+	pki := newTestPKI()
+
+	auth := NewCertificateAuthenticator(NewMemoryBackend())
+	auth.Identity = DNSNameIdentity
+	auth.EnforceClientID = true
+
+	port, quit, done := runCertificateEngine(auth, pki, nil)
+
+	cert := pki.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "foo"},
+		DNSNames:    []string{"device1.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	code := certificateConnect(t, certificateConfig(port, "device1.example.com", pki, &cert))
+	assert.Equal(t, packet.ConnectionAccepted, code)
+
+	code = certificateConnect(t, certificateConfig(port, "device2.example.com", pki, &cert))
+	assert.Equal(t, packet.ErrNotAuthorized, code)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestCertificateAuthenticatorMapClientID(t *testing.T) {
+	pki := newTestPKI()
+
+	auth := NewCertificateAuthenticator(NewMemoryBackend())
+	auth.MapClientID = true
+
+	clientIDs := make(chan string, 1)
+	port, quit, done := runCertificateEngine(auth, pki, func(event LogEvent, c *Client, pkt packet.GenericPacket, _ *packet.Message, _ error) {
+		if _, ok := pkt.(*packet.ConnackPacket); ok && event == PacketSent && c.ClientID() != "" {
+			clientIDs <- c.ClientID()
+		}
+	})
+
+	cert := pki.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "device1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	code := certificateConnect(t, certificateConfig(port, "", pki, &cert))
+	assert.Equal(t, packet.ConnectionAccepted, code)
+	assert.Equal(t, "device1", <-clientIDs)
+
+	close(quit)
+	safeReceive(done)
+}
@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"sync"
@@ -23,6 +24,11 @@ const (
 // ConnectPacket.
 var ErrExpectedConnect = errors.New("expected a ConnectPacket as the first packet")
 
+// ErrSubscriptionDenied can be returned by Backend.Subscribe to deny a
+// subscription without closing the client. The subscription will be marked as
+// failed in the SubackPacket.
+var ErrSubscriptionDenied = errors.New("subscription denied")
+
 // A Client represents a remote client that is connected to the broker.
 type Client struct {
 	state uint32
@@ -36,9 +42,15 @@ type Client struct {
 
 	replaced bool
 
+	ip        *string
+	connected bool
+	rejected  error
+
 	clientID     string
+	username     string
 	cleanSession bool
 	session      Session
+	rateLimit    *rateLimitState
 
 	out chan *packet.Message
 
@@ -47,13 +59,15 @@ type Client struct {
 	finish sync.Once
 }
 
-// newClient takes over a connection and returns a Client
-func newClient(engine *Engine, conn transport.Conn) *Client {
+// newClient takes over a connection and returns a Client, the client is closed
+// immediately if the connection has been rejected by the engine
+func newClient(engine *Engine, conn transport.Conn, rejected error) *Client {
 	c := &Client{
-		state:  clientConnecting,
-		engine: engine,
-		conn:   conn,
-		out:    make(chan *packet.Message),
+		state:    clientConnecting,
+		engine:   engine,
+		conn:     conn,
+		rejected: rejected,
+		out:      make(chan *packet.Message),
 	}
 
 	// start processor
@@ -72,17 +86,46 @@ func (c *Client) CleanSession() bool {
 	return c.cleanSession
 }
 
-// ClientID returns the supplied client id during connect.
+// ClientID returns the supplied client id during connect or the client id that
+// has been assigned by the Backend during authentication.
 func (c *Client) ClientID() string {
 	return c.clientID
 }
 
+// Username returns the supplied username during connect or the username that
+// has been assigned by the Backend during authentication.
+func (c *Client) Username() string {
+	return c.username
+}
+
+// TLSConnectionState returns the state of the underlying TLS connection and
+// true, or false if the client is not connected using TLS.
+func (c *Client) TLSConnectionState() (tls.ConnectionState, bool) {
+	if conn, ok := c.conn.(transport.TLSConn); ok {
+		return conn.TLSConnectionState()
+	}
+
+	return tls.ConnectionState{}, false
+}
+
 // RemoteAddr returns the client's remote net address from the
 // underlying connection.
 func (c *Client) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// ProxyHeader returns the PROXY protocol header of the underlying connection
+// or nil if the connection has not been accepted by a proxy server.
+func (c *Client) ProxyHeader() *transport.ProxyHeader {
+	if conn, ok := c.conn.(interface {
+		ProxyHeader() *transport.ProxyHeader
+	}); ok {
+		return conn.ProxyHeader()
+	}
+
+	return nil
+}
+
 // Publish will send a Message to the client and initiate QOS flows.
 func (c *Client) Publish(msg *packet.Message) bool {
 	select {
@@ -112,11 +155,25 @@ func (c *Client) Close(clean bool) {
 func (c *Client) processor() error {
 	first := true
 
+	// close rejected connection
+	if c.rejected != nil {
+		return c.die(ConnectionRejected, c.rejected, true)
+	}
+
 	c.log(NewConnection, c, nil, nil, nil)
 
-	// set initial read timeout
+	// set initial read timeout, this also limits the time to read a PROXY
+	// protocol header when the remote address is resolved
 	c.conn.SetReadTimeout(c.engine.ConnectTimeout)
 
+	// check per ip limit
+	if c.engine.MaxConnectionsPerIP > 0 {
+		err := c.engine.admitIP(c, remoteIP(c.conn.RemoteAddr()))
+		if err != nil {
+			return c.die(ConnectionRejected, err, true)
+		}
+	}
+
 	for {
 		// get next packet from connection
 		pkt, err := c.conn.Receive()
@@ -136,6 +193,9 @@ func (c *Client) processor() error {
 			// process connect
 			err = c.processConnect(connect)
 			first = false
+
+			// remove pending connect
+			c.engine.connected(c)
 		}
 
 		switch typedPkt := pkt.(type) {
@@ -171,6 +231,28 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	// set values
 	c.cleanSession = pkt.CleanSession
 	c.clientID = pkt.ClientID
+	c.username = pkt.Username
+
+	// prepare connack packet
+	connack := packet.NewConnackPacket()
+	connack.ReturnCode = packet.ConnectionAccepted
+	connack.SessionPresent = false
+
+	// reject client if draining, this is checked before authenticating to
+	// ensure that Terminate is called for all authenticated clients
+	if c.engine.Draining() {
+		// set return code
+		connack.ReturnCode = packet.ErrServerUnavailable
+
+		// send connack
+		err := c.send(connack, false)
+		if err != nil {
+			return c.die(TransportError, err, false)
+		}
+
+		// close client
+		return c.die(ConnectionRejected, ErrDraining, true)
+	}
 
 	// authenticate
 	ok, err := c.engine.Backend.Authenticate(c, pkt.Username, pkt.Password)
@@ -178,11 +260,6 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 		return c.die(BackendError, err, true)
 	}
 
-	// prepare connack packet
-	connack := packet.NewConnackPacket()
-	connack.ReturnCode = packet.ConnectionAccepted
-	connack.SessionPresent = false
-
 	// check authentication
 	if !ok {
 		// set return code
@@ -198,6 +275,11 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 		return c.die(ClientError, nil, true)
 	}
 
+	// prepare rate limit
+	if c.engine.RateLimiter != nil {
+		c.rateLimit = newRateLimitState(c.engine.RateLimiter, c.clientID, c.username, time.Now())
+	}
+
 	// set state
 	atomic.StoreUint32(&c.state, clientConnected)
 
@@ -212,7 +294,7 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	}
 
 	// retrieve session
-	s, resumed, err := c.engine.Backend.Setup(c, pkt.ClientID)
+	s, resumed, err := c.engine.Backend.Setup(c, c.clientID)
 	if err != nil {
 		return c.die(BackendError, err, true)
 	}
@@ -226,7 +308,9 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	connack.SessionPresent = !pkt.CleanSession && resumed
 
 	// assign session
+	c.mutex.Lock()
 	c.session = s
+	c.mutex.Unlock()
 
 	// save will if present
 	if pkt.Will != nil {
@@ -277,7 +361,15 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 		// resubscribe subscriptions
 		for _, sub := range subs {
 			err = c.engine.Backend.Subscribe(c, sub)
-			if err != nil {
+			if err == ErrSubscriptionDenied {
+				// remove denied subscription from session
+				err = c.session.DeleteSubscription(sub.Topic)
+				if err != nil {
+					return c.die(SessionError, err, true)
+				}
+
+				continue
+			} else if err != nil {
 				return c.die(BackendError, err, true)
 			}
 		}
@@ -310,20 +402,33 @@ func (c *Client) processSubscribe(pkt *packet.SubscribePacket) error {
 	suback.ReturnCodes = make([]byte, len(pkt.Subscriptions))
 	suback.ID = pkt.ID
 
+	// check draining
+	draining := c.engine.Draining()
+
 	// handle contained subscriptions
 	for i, subscription := range pkt.Subscriptions {
-		// save subscription in session
-		err := c.session.SaveSubscription(&subscription)
-		if err != nil {
-			return c.die(SessionError, err, true)
+		// deny subscriptions while draining
+		if draining {
+			suback.ReturnCodes[i] = packet.QOSFailure
+			continue
 		}
 
 		// subscribe client to queue
-		err = c.engine.Backend.Subscribe(c, &subscription)
-		if err != nil {
+		err := c.engine.Backend.Subscribe(c, &subscription)
+		if err == ErrSubscriptionDenied {
+			// mark subscription as failed
+			suback.ReturnCodes[i] = packet.QOSFailure
+			continue
+		} else if err != nil {
 			return c.die(BackendError, err, true)
 		}
 
+		// save subscription in session
+		err = c.session.SaveSubscription(&subscription)
+		if err != nil {
+			return c.die(SessionError, err, true)
+		}
+
 		// save granted qos
 		suback.ReturnCodes[i] = subscription.QOS
 	}
@@ -335,7 +440,12 @@ func (c *Client) processSubscribe(pkt *packet.SubscribePacket) error {
 	}
 
 	// queue retained messages
-	for _, sub := range pkt.Subscriptions {
+	for i, sub := range pkt.Subscriptions {
+		// skip failed subscriptions
+		if suback.ReturnCodes[i] == packet.QOSFailure {
+			continue
+		}
+
 		err := c.engine.Backend.QueueRetained(c, sub.Topic)
 		if err != nil {
 			return c.die(BackendError, err, true)
@@ -377,8 +487,34 @@ func (c *Client) processUnsubscribe(pkt *packet.UnsubscribePacket) error {
 
 // handle an incoming PublishPacket
 func (c *Client) processPublish(publish *packet.PublishPacket) error {
+	// check rate limit
+	drop := false
+	if c.rateLimit != nil {
+		exceeded, action, wait := c.rateLimit.check(&publish.Message, time.Now())
+		if exceeded {
+			c.log(RateLimitExceeded, c, publish, &publish.Message, ErrRateLimitExceeded)
+
+			// handle action
+			switch action {
+			case RateLimitDrop:
+				drop = true
+				c.log(MessageDropped, c, publish, &publish.Message, ErrRateLimitExceeded)
+			case RateLimitDisconnect:
+				return c.die(ClientError, ErrRateLimitExceeded, true)
+			}
+		}
+
+		// delay reading
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-c.tomb.Dying():
+			}
+		}
+	}
+
 	// handle unacknowledged and directly acknowledged messages
-	if publish.Message.QOS <= 1 {
+	if publish.Message.QOS <= 1 && !drop {
 		err := c.handleMessage(&publish.Message)
 		if err != nil {
 			return c.die(BackendError, err, true)
@@ -399,10 +535,15 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 
 	// handle qos 2 flow
 	if publish.Message.QOS == 2 {
-		// store packet
-		err := c.session.SavePacket(session.Incoming, publish)
-		if err != nil {
-			return c.die(SessionError, err, true)
+		if drop {
+			// remember dropped packet
+			c.rateLimit.dropped[publish.ID] = true
+		} else {
+			// store packet
+			err := c.session.SavePacket(session.Incoming, publish)
+			if err != nil {
+				return c.die(SessionError, err, true)
+			}
 		}
 
 		// prepare pubrec packet
@@ -410,7 +551,7 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 		pubrec.ID = publish.ID
 
 		// signal qos 2 publish
-		err = c.send(pubrec, true)
+		err := c.send(pubrec, true)
 		if err != nil {
 			return c.die(TransportError, err, false)
 		}
@@ -459,6 +600,21 @@ func (c *Client) processPubrel(id packet.ID) error {
 	// get packet from store
 	publish, ok := pkt.(*packet.PublishPacket)
 	if !ok {
+		// complete dropped packet
+		if c.rateLimit != nil && c.rateLimit.dropped[id] {
+			delete(c.rateLimit.dropped, id)
+
+			// prepare pubcomp packet
+			pubcomp := packet.NewPubcompPacket()
+			pubcomp.ID = id
+
+			// acknowledge PublishPacket
+			err = c.send(pubcomp, true)
+			if err != nil {
+				return c.die(TransportError, err, false)
+			}
+		}
+
 		return nil // ignore a wrongly sent PubrelPacket
 	}
 
@@ -554,6 +710,28 @@ func (c *Client) sender() error {
 
 /* helpers */
 
+// returns whether the client has unfinished QOS 1 or 2 flows
+func (c *Client) inflight() bool {
+	c.mutex.Lock()
+	s := c.session
+	c.mutex.Unlock()
+
+	// clients without a session have no flows
+	if s == nil {
+		return false
+	}
+
+	// check stored packets
+	for _, dir := range []session.Direction{session.Incoming, session.Outgoing} {
+		packets, err := s.AllPackets(dir)
+		if err != nil || len(packets) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Client) handleMessage(msg *packet.Message) error {
 	// check retain flag
 	if msg.Retain {
@@ -653,6 +831,9 @@ func (c *Client) die(event LogEvent, err error, close bool) error {
 		if err != nil {
 			c.log(event, c, nil, nil, err)
 		}
+
+		// release connection
+		c.engine.release(c)
 	})
 
 	return err
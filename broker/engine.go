@@ -2,6 +2,7 @@
 package broker
 
 import (
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -44,8 +45,48 @@ const (
 
 	// ClientError is emitted when the client violates the protocol.
 	ClientError
+
+	// RateLimitExceeded is emitted when a published message exceeds a rate
+	// limit.
+	RateLimitExceeded
+
+	// MessageDropped is emitted when a published message has been dropped
+	// because it exceeded a rate limit.
+	MessageDropped
+
+	// ConnectionRejected is emitted when a connection has been closed because
+	// it exceeded a connection limit or the engine is draining.
+	ConnectionRejected
 )
 
+// ErrTooManyConnections is emitted with ConnectionRejected if the total
+// number of connections has been reached.
+var ErrTooManyConnections = errors.New("too many connections")
+
+// ErrTooManyConnectionsPerIP is emitted with ConnectionRejected if the number
+// of connections from a single remote ip has been reached.
+var ErrTooManyConnectionsPerIP = errors.New("too many connections per ip")
+
+// ErrConnectionRateExceeded is emitted with ConnectionRejected if the
+// connection rate has been exceeded.
+var ErrConnectionRateExceeded = errors.New("connection rate exceeded")
+
+// ErrDraining is emitted with ConnectionRejected if the engine is draining.
+var ErrDraining = errors.New("engine is draining")
+
+// ErrTooManyPendingConnects is emitted with ConnectionRejected if the number
+// of connections that have not yet sent a ConnectPacket has been reached.
+var ErrTooManyPendingConnects = errors.New("too many pending connects")
+
+// A SessionPersister is an optional interface that can be implemented by a
+// Backend to persist the session of a client before it is disconnected during
+// a drain.
+type SessionPersister interface {
+	// PersistSession should persist the session of the client so it can be
+	// resumed on another node.
+	PersistSession(*Client) error
+}
+
 // The Logger callback handles incoming log messages.
 type Logger func(LogEvent, *Client, packet.GenericPacket, *packet.Message, error)
 
@@ -57,8 +98,34 @@ type Engine struct {
 	ConnectTimeout   time.Duration
 	DefaultReadLimit int64
 
-	closing   bool
-	clients   []*Client
+	// RateLimiter is applied to messages published by clients if set.
+	RateLimiter *RateLimiter
+
+	// MaxConnections limits the total number of connections.
+	MaxConnections int
+
+	// MaxConnectionsPerIP limits the number of connections per remote ip.
+	MaxConnectionsPerIP int
+
+	// ConnectionRate limits the accepted connections per second. If the
+	// burst is zero, the rate is used as the burst.
+	ConnectionRate  float64
+	ConnectionBurst float64
+
+	// MaxPendingConnects limits the number of connections that have not yet
+	// been authenticated. As pending connections are closed after the
+	// ConnectTimeout, the limit should be chosen in relation to it.
+	MaxPendingConnects int
+
+	closing  bool
+	draining bool
+	clients  []*Client
+
+	connections      int
+	pendingConnects  int
+	ipConnections    map[string]int
+	connectionBucket *tokenBucket
+
 	mutex     sync.Mutex
 	waitGroup sync.WaitGroup
 
@@ -98,9 +165,6 @@ func (e *Engine) Accept(server transport.Server) {
 // Handle takes over responsibility and handles a transport.Conn. It returns
 // false if the engine is closing and the connection has been closed.
 func (e *Engine) Handle(conn transport.Conn) bool {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
-
 	// check conn
 	if conn == nil {
 		panic("passed conn is nil")
@@ -109,14 +173,22 @@ func (e *Engine) Handle(conn transport.Conn) bool {
 	// set default read limit
 	conn.SetReadLimit(e.DefaultReadLimit)
 
+	e.mutex.Lock()
+
 	// close conn immediately when closing
 	if e.closing {
+		e.mutex.Unlock()
 		conn.Close()
 		return false
 	}
 
+	// check limits, rejected connections are closed by the client
+	err := e.admit()
+
 	// handle client
-	newClient(e, conn)
+	newClient(e, conn, err)
+
+	e.mutex.Unlock()
 
 	return true
 }
@@ -173,6 +245,192 @@ func (e *Engine) Wait(timeout time.Duration) bool {
 	}
 }
 
+// Drain will put the engine in drain mode to support zero-downtime deploys.
+// In drain mode, new connections are rejected and new subscriptions are marked
+// as failed. The call will then wait up to the specified timeout for the
+// inflight QOS 1 and 2 flows of all clients to complete. Afterwards, the
+// sessions are persisted if the Backend implements SessionPersister and the
+// clients are disconnected in batches of the specified size that are separated
+// by the specified interval, so they reconnect to other nodes gradually. The
+// method returns whether all inflight flows completed in time.
+//
+// Note: Close should be called afterwards to finally shutdown the engine.
+func (e *Engine) Drain(timeout time.Duration, batch int, interval time.Duration) bool {
+	// set draining
+	e.mutex.Lock()
+	e.draining = true
+	e.mutex.Unlock()
+
+	// wait for inflight flows
+	completed := false
+	deadline := time.Now().Add(timeout)
+	for {
+		// check clients
+		completed = true
+		for _, client := range e.Clients() {
+			if client.inflight() {
+				completed = false
+				break
+			}
+		}
+
+		// check result and deadline
+		if completed || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// disconnect clients in batches
+	disconnected := make(map[*Client]bool)
+	for {
+		// get next batch
+		var clients []*Client
+		for _, client := range e.Clients() {
+			if !disconnected[client] && (batch <= 0 || len(clients) < batch) {
+				clients = append(clients, client)
+			}
+		}
+
+		// check if done
+		if len(clients) == 0 {
+			break
+		}
+
+		// wait interval between batches
+		if len(disconnected) > 0 {
+			time.Sleep(interval)
+		}
+
+		for _, client := range clients {
+			// persist session
+			if persister, ok := e.Backend.(SessionPersister); ok {
+				err := persister.PersistSession(client)
+				if err != nil && e.Logger != nil {
+					e.Logger(BackendError, client, nil, nil, err)
+				}
+			}
+
+			// close client
+			client.Close(true)
+			disconnected[client] = true
+		}
+	}
+
+	return completed
+}
+
+// Draining returns whether the engine is in drain mode.
+func (e *Engine) Draining() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.draining
+}
+
+// admit checks the connection limits and counts a new pending connection
+func (e *Engine) admit() error {
+	// check draining
+	if e.draining {
+		return ErrDraining
+	}
+
+	// check total connections
+	if e.MaxConnections > 0 && e.connections >= e.MaxConnections {
+		return ErrTooManyConnections
+	}
+
+	// check pending connects
+	if e.MaxPendingConnects > 0 && e.pendingConnects >= e.MaxPendingConnects {
+		return ErrTooManyPendingConnects
+	}
+
+	// check connection rate
+	if e.ConnectionRate > 0 {
+		now := time.Now()
+
+		// create bucket
+		if e.connectionBucket == nil {
+			e.connectionBucket = newTokenBucket(e.ConnectionRate, e.ConnectionBurst, now)
+		}
+
+		if !e.connectionBucket.available(1, now) {
+			return ErrConnectionRateExceeded
+		}
+
+		e.connectionBucket.take(1, now)
+	}
+
+	// count connection
+	e.connections++
+	e.pendingConnects++
+
+	return nil
+}
+
+// clients call admitIP to check the per ip limit before reading the first packet
+func (e *Engine) admitIP(client *Client, ip string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// check ip connections
+	if e.ipConnections[ip] >= e.MaxConnectionsPerIP {
+		return ErrTooManyConnectionsPerIP
+	}
+
+	// create map
+	if e.ipConnections == nil {
+		e.ipConnections = make(map[string]int)
+	}
+
+	// count connection
+	e.ipConnections[ip]++
+	client.ip = &ip
+
+	return nil
+}
+
+// clients call connected when the ConnectPacket has been processed
+func (e *Engine) connected(client *Client) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// remove pending connect
+	if !client.connected {
+		client.connected = true
+		e.pendingConnects--
+	}
+}
+
+// clients call release when they are closed to release their connection
+func (e *Engine) release(client *Client) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// ignore rejected clients as they have not been counted
+	if client.rejected != nil {
+		return
+	}
+
+	// remove pending connect
+	if !client.connected {
+		client.connected = true
+		e.pendingConnects--
+	}
+
+	// remove ip connection
+	if client.ip != nil {
+		e.ipConnections[*client.ip]--
+		if e.ipConnections[*client.ip] <= 0 {
+			delete(e.ipConnections, *client.ip)
+		}
+	}
+
+	// remove connection
+	e.connections--
+}
+
 // clients call add to add themselves to the list
 func (e *Engine) add(client *Client) {
 	e.mutex.Lock()
@@ -248,3 +506,13 @@ func Run(engine *Engine, protocol string) (string, chan struct{}, chan struct{})
 
 	return port, quit, done
 }
+
+func remoteIP(addr net.Addr) string {
+	// split host and port
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
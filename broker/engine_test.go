@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"net"
 	"testing"
 	"time"
 
@@ -50,3 +51,302 @@ func TestDefaultReadLimit(t *testing.T) {
 	close(quit)
 	safeReceive(done)
 }
+
+func rejectedLogger(rejected chan error) Logger {
+	return func(event LogEvent, c *Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+		if event == ConnectionRejected {
+			rejected <- err
+		}
+	}
+}
+
+func dialRejected(t *testing.T, port string) {
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	pkt, err := conn.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	for _, item := range []struct {
+		configure func(*Engine)
+		err       error
+	}{
+		{
+			configure: func(e *Engine) { e.MaxConnections = 1 },
+			err:       ErrTooManyConnections,
+		},
+		{
+			configure: func(e *Engine) { e.MaxConnectionsPerIP = 1 },
+			err:       ErrTooManyConnectionsPerIP,
+		},
+		{
+			configure: func(e *Engine) { e.ConnectionRate = 0.001 },
+			err:       ErrConnectionRateExceeded,
+		},
+	} {
+		engine := NewEngine()
+		item.configure(engine)
+
+		rejected := make(chan error, 1)
+		engine.Logger = rejectedLogger(rejected)
+
+		port, quit, done := Run(engine, "tcp")
+
+		c := client.New()
+
+		cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+		assert.NoError(t, err)
+		assert.NoError(t, cf.Wait(10*time.Second))
+
+		dialRejected(t, port)
+		assert.Equal(t, item.err, <-rejected)
+
+		assert.NoError(t, c.Disconnect())
+
+		close(quit)
+		safeReceive(done)
+	}
+}
+
+func TestConnectionLimitsRelease(t *testing.T) {
+	engine := NewEngine()
+	engine.MaxConnections = 1
+	engine.MaxConnectionsPerIP = 1
+
+	port, quit, done := Run(engine, "tcp")
+
+	for i := 0; i < 3; i++ {
+		c := client.New()
+
+		cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+		assert.NoError(t, err)
+		assert.NoError(t, cf.Wait(10*time.Second))
+
+		assert.NoError(t, c.Disconnect())
+
+		// wait until the connection has been released
+		for {
+			engine.mutex.Lock()
+			connections := engine.connections
+			engine.mutex.Unlock()
+
+			if connections == 0 {
+				break
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestMaxConnectionsPerIPSilentProxyConnection(t *testing.T) {
+	engine := NewEngine()
+	engine.MaxConnectionsPerIP = 10
+
+	server, err := transport.NewProxyNetServer("localhost:0", &transport.ProxyConfig{})
+	assert.NoError(t, err)
+	engine.Accept(server)
+
+	// open a connection that never sends the header
+	silent, err := net.Dial("tcp", server.Addr().String())
+	assert.NoError(t, err)
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfig("tcp://" + server.Addr().String()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	assert.NoError(t, c.Disconnect())
+	assert.NoError(t, silent.Close())
+
+	server.Close()
+	engine.Close()
+	assert.True(t, engine.Wait(time.Second))
+}
+
+func TestMaxPendingConnects(t *testing.T) {
+	engine := NewEngine()
+	engine.MaxPendingConnects = 1
+
+	connected := make(chan struct{}, 1)
+	rejected := make(chan error, 1)
+	engine.Logger = func(event LogEvent, c *Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+		switch event {
+		case NewConnection:
+			connected <- struct{}{}
+		case ConnectionRejected:
+			assert.NotNil(t, c.RemoteAddr())
+			rejected <- err
+		}
+	}
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+	safeReceive(connected)
+
+	dialRejected(t, port)
+	assert.Equal(t, ErrTooManyPendingConnects, <-rejected)
+
+	connect := packet.NewConnectPacket()
+	connect.ClientID = "test"
+	assert.NoError(t, conn.Send(connect))
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ConnectionAccepted, pkt.(*packet.ConnackPacket).ReturnCode)
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+	safeReceive(connected)
+
+	assert.NoError(t, c.Disconnect())
+	assert.NoError(t, conn.Close())
+
+	close(quit)
+	safeReceive(done)
+}
+
+type persistingBackend struct {
+	*MemoryBackend
+
+	persisted chan string
+}
+
+func (b *persistingBackend) PersistSession(client *Client) error {
+	b.persisted <- client.ClientID()
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	backend := &persistingBackend{
+		MemoryBackend: NewMemoryBackend(),
+		persisted:     make(chan string, 3),
+	}
+
+	engine := NewEngineWithBackend(backend)
+
+	port, quit, done := Run(engine, "tcp")
+
+	closed := make(chan struct{}, 3)
+
+	for _, id := range []string{"c1", "c2", "c3"} {
+		c := client.New()
+		c.Callback = func(msg *packet.Message, err error) error {
+			assert.Error(t, err)
+			closed <- struct{}{}
+			return nil
+		}
+
+		cf, err := c.Connect(client.NewConfigWithClientID("tcp://localhost:"+port, id))
+		assert.NoError(t, err)
+		assert.NoError(t, cf.Wait(10*time.Second))
+	}
+
+	assert.True(t, engine.Drain(time.Second, 2, 10*time.Millisecond))
+	assert.True(t, engine.Draining())
+
+	safeReceive(closed)
+	safeReceive(closed)
+	safeReceive(closed)
+
+	assert.Len(t, backend.persisted, 3)
+
+	dialRejected(t, port)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestDrainSilentConnection(t *testing.T) {
+	engine := NewEngine()
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.True(t, engine.Drain(time.Second, 0, 0))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	err = conn.Close()
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestDrainInflight(t *testing.T) {
+	engine := NewEngine()
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnectPacket()
+	connect.ClientID = "test"
+	assert.NoError(t, conn.Send(connect))
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.IsType(t, &packet.ConnackPacket{}, pkt)
+
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = 1
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	assert.NoError(t, conn.Send(subscribe))
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint8{1}, pkt.(*packet.SubackPacket).ReturnCodes)
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 2
+	publish.Message = packet.Message{Topic: "test", Payload: []byte("test"), QOS: 1}
+	assert.NoError(t, conn.Send(publish))
+
+	// receive puback and publish without acknowledging the publish
+	for i := 0; i < 2; i++ {
+		_, err = conn.Receive()
+		assert.NoError(t, err)
+	}
+
+	result := make(chan bool)
+
+	go func() {
+		result <- engine.Drain(200*time.Millisecond, 0, 0)
+	}()
+
+	for !engine.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	subscribe.ID = 3
+	assert.NoError(t, conn.Send(subscribe))
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint8{packet.QOSFailure}, pkt.(*packet.SubackPacket).ReturnCodes)
+
+	assert.False(t, <-result)
+
+	pkt, err = conn.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
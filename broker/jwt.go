@@ -0,0 +1,406 @@
+package broker
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	// register hash functions
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// ErrMalformedToken is returned by VerifyJWT if the token cannot be decoded.
+var ErrMalformedToken = errors.New("malformed token")
+
+// ErrUnsupportedAlgorithm is returned by VerifyJWT if the token uses an
+// unsupported signing algorithm.
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// ErrInvalidSignature is returned by VerifyJWT if none of the keys could
+// verify the token's signature.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrMalformedKey is returned when loading a key that cannot be decoded.
+var ErrMalformedKey = errors.New("malformed key")
+
+// A JWTKey is a key used to verify the signature of a JWT.
+type JWTKey struct {
+	// ID is matched against the "kid" header of a token. Keys without an id
+	// are used for all tokens.
+	ID string
+
+	// Algorithm optionally restricts the key to the specified algorithm.
+	Algorithm string
+
+	// Key is either a []byte for HMAC, a *rsa.PublicKey for RSA or a
+	// *ecdsa.PublicKey for ECDSA signatures.
+	Key interface{}
+}
+
+// LoadJWTKeyFile will load a key from the specified file. PEM encoded public
+// keys and certificates are loaded as RSA or ECDSA keys, any other content is
+// used as a HMAC secret.
+func LoadJWTKeyFile(path, id string) (*JWTKey, error) {
+	// read file
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// use content as secret if not PEM encoded
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return &JWTKey{
+			ID:  id,
+			Key: bytes.TrimSpace(data),
+		}, nil
+	}
+
+	// parse key
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, ErrMalformedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// check key type
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, ErrMalformedKey
+	}
+
+	return &JWTKey{
+		ID:  id,
+		Key: key,
+	}, nil
+}
+
+// LoadJWKSFile will load all supported keys from the specified JWKS file.
+func LoadJWKSFile(path string) ([]*JWTKey, error) {
+	// read file
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(data)
+}
+
+// ParseJWKS will parse all supported keys from the specified JWKS document.
+// Keys that are not meant for signature verification are skipped.
+func ParseJWKS(data []byte) ([]*JWTKey, error) {
+	// decode document
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare list
+	var keys []*JWTKey
+
+	for _, jwk := range doc.Keys {
+		// skip encryption keys
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// prepare key
+		key := &JWTKey{
+			ID:        jwk.Kid,
+			Algorithm: jwk.Alg,
+		}
+
+		switch jwk.Kty {
+		case "RSA":
+			n, err1 := decodeSegment(jwk.N)
+			e, err2 := decodeSegment(jwk.E)
+			if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 {
+				return nil, ErrMalformedKey
+			}
+
+			key.Key = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, ErrMalformedKey
+			}
+
+			x, err1 := decodeSegment(jwk.X)
+			y, err2 := decodeSegment(jwk.Y)
+			if err1 != nil || err2 != nil || len(x) == 0 || len(y) == 0 {
+				return nil, ErrMalformedKey
+			}
+
+			key.Key = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		case "oct":
+			k, err := decodeSegment(jwk.K)
+			if err != nil || len(k) == 0 {
+				return nil, ErrMalformedKey
+			}
+
+			key.Key = k
+		default:
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// JWTClaims are the decoded claims of a JWT.
+type JWTClaims map[string]interface{}
+
+// String returns the named claim if it is a string.
+func (c JWTClaims) String(name string) string {
+	str, _ := c[name].(string)
+	return str
+}
+
+// Strings returns the named claim if it is a string or a list of strings.
+func (c JWTClaims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				list = append(list, str)
+			}
+		}
+
+		return list
+	}
+
+	return nil
+}
+
+// Time returns the named claim if it is a numeric date.
+func (c JWTClaims) Time(name string) (time.Time, bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(value), 0), true
+}
+
+// VerifyJWT will decode the specified token and verify its signature using
+// the matching keys. It will return the decoded claims if the signature is
+// valid. The claims itself are not validated.
+func VerifyJWT(token string, keys []*JWTKey) (JWTClaims, error) {
+	// split token
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	// decode header
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJSONSegment(parts[0], &header)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	// decode signature
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	// check algorithm
+	if len(header.Alg) != 5 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	// get family
+	family := header.Alg[:2]
+	switch family {
+	case "HS", "RS", "PS", "ES":
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	// get hash
+	var hash crypto.Hash
+	switch header.Alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	// get signed data
+	signed := []byte(parts[0] + "." + parts[1])
+
+	// check signature with all matching keys
+	verified := false
+	for _, key := range keys {
+		// check id and algorithm
+		if (key.ID != "" && header.Kid != "" && key.ID != header.Kid) ||
+			(key.Algorithm != "" && key.Algorithm != header.Alg) {
+			continue
+		}
+
+		// verify signature
+		ok, err := verifySignature(family, hash, key.Key, signed, signature)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			verified = true
+			break
+		}
+	}
+
+	// check result
+	if !verified {
+		return nil, ErrInvalidSignature
+	}
+
+	// decode claims
+	var claims JWTClaims
+	err = decodeJSONSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	return claims, nil
+}
+
+func verifySignature(family string, hash crypto.Hash, key interface{}, signed, signature []byte) (bool, error) {
+	switch family {
+	case "HS":
+		// check key
+		secret, ok := key.([]byte)
+		if !ok {
+			return false, nil
+		}
+
+		// compute mac
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+
+		return hmac.Equal(mac.Sum(nil), signature), nil
+	case "RS", "PS":
+		// check key
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+
+		// compute digest
+		h := hash.New()
+		h.Write(signed)
+
+		// verify signature
+		var err error
+		if family == "RS" {
+			err = rsa.VerifyPKCS1v15(publicKey, hash, h.Sum(nil), signature)
+		} else {
+			err = rsa.VerifyPSS(publicKey, hash, h.Sum(nil), signature, nil)
+		}
+
+		return err == nil, nil
+	case "ES":
+		// check key
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+
+		// check signature size
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false, nil
+		}
+
+		// compute digest
+		h := hash.New()
+		h.Write(signed)
+
+		// verify signature
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		return ecdsa.Verify(publicKey, h.Sum(nil), r, s), nil
+	}
+
+	return false, ErrUnsupportedAlgorithm
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+func decodeJSONSegment(segment string, value interface{}) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
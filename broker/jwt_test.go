@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeJWT(alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	digest := hash.New()
+	digest.Write([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k, hash, digest.Sum(nil))
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[size-len(rb):size], rb)
+		copy(signature[2*size-len(sb):], sb)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	keys := []*JWTKey{
+		{ID: "hmac", Key: secret},
+		{ID: "rsa", Key: &rsaKey.PublicKey},
+		{ID: "ec", Key: &ecKey.PublicKey},
+	}
+
+	claims := map[string]interface{}{"sub": "device1"}
+
+	for _, item := range []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{"HS256", "hmac", secret},
+		{"HS512", "", secret},
+		{"RS256", "rsa", rsaKey},
+		{"RS384", "", rsaKey},
+		{"ES256", "ec", ecKey},
+	} {
+		res, err := VerifyJWT(makeJWT(item.alg, item.kid, item.key, claims), keys)
+		assert.NoError(t, err, item.alg)
+		assert.Equal(t, "device1", res.String("sub"), item.alg)
+	}
+
+	res, err := VerifyJWT(makeJWT("HS256", "", []byte("foo"), claims), keys)
+	assert.Equal(t, ErrInvalidSignature, err)
+	assert.Nil(t, res)
+
+	res, err = VerifyJWT(makeJWT("HS256", "rsa", secret, claims), keys)
+	assert.Equal(t, ErrInvalidSignature, err)
+	assert.Nil(t, res)
+
+	res, err = VerifyJWT("foo.bar", keys)
+	assert.Equal(t, ErrMalformedToken, err)
+	assert.Nil(t, res)
+
+	res, err = VerifyJWT(base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))+".e30.", keys)
+	assert.Equal(t, ErrUnsupportedAlgorithm, err)
+	assert.Nil(t, res)
+}
+
+func TestJWTClaims(t *testing.T) {
+	claims := JWTClaims{
+		"aud":  []interface{}{"a", "b"},
+		"iss":  "c",
+		"exp":  float64(1000),
+		"list": []interface{}{"d", 1},
+	}
+
+	assert.Equal(t, []string{"a", "b"}, claims.Strings("aud"))
+	assert.Equal(t, []string{"c"}, claims.Strings("iss"))
+	assert.Equal(t, []string{"d"}, claims.Strings("list"))
+	assert.Equal(t, "c", claims.String("iss"))
+	assert.Equal(t, "", claims.String("aud"))
+
+	exp, ok := claims.Time("exp")
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1000, 0), exp)
+
+	_, ok = claims.Time("nbf")
+	assert.False(t, ok)
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	enc := base64.RawURLEncoding.EncodeToString
+
+	doc := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa","alg":"RS256","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"oct","kid":"hmac","k":"%s"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"%s"}
+	]}`,
+		enc(rsaKey.N.Bytes()), enc([]byte{1, 0, 1}),
+		enc(ecKey.X.Bytes()), enc(ecKey.Y.Bytes()),
+		enc([]byte("secret")),
+		enc(rsaKey.N.Bytes()), enc([]byte{1, 0, 1}))
+
+	keys, err := ParseJWKS([]byte(doc))
+	require.NoError(t, err)
+	assert.Len(t, keys, 3)
+
+	claims := map[string]interface{}{"sub": "device1"}
+
+	_, err = VerifyJWT(makeJWT("RS256", "rsa", rsaKey, claims), keys)
+	assert.NoError(t, err)
+
+	_, err = VerifyJWT(makeJWT("RS512", "rsa", rsaKey, claims), keys)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	_, err = VerifyJWT(makeJWT("ES256", "ec", ecKey, claims), keys)
+	assert.NoError(t, err)
+
+	_, err = VerifyJWT(makeJWT("HS256", "hmac", []byte("secret"), claims), keys)
+	assert.NoError(t, err)
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"foo"}]}`))
+	assert.Equal(t, ErrMalformedKey, err)
+}
+
+func TestLoadJWTKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+
+	pemFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	secretFile := filepath.Join(dir, "secret")
+	err = ioutil.WriteFile(secretFile, []byte("secret\n"), 0600)
+	require.NoError(t, err)
+
+	key, err := LoadJWTKeyFile(pemFile, "ec")
+	require.NoError(t, err)
+	assert.Equal(t, "ec", key.ID)
+	assert.IsType(t, &ecdsa.PublicKey{}, key.Key)
+
+	key, err = LoadJWTKeyFile(secretFile, "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key.Key)
+
+	_, err = LoadJWTKeyFile(filepath.Join(dir, "missing"), "")
+	assert.Error(t, err)
+}
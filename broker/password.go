@@ -0,0 +1,179 @@
+package broker
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrUnsupportedHash is returned by HashPassword and VerifyPassword if the
+// hash algorithm is not supported.
+var ErrUnsupportedHash = errors.New("unsupported hash")
+
+// ErrMalformedHash is returned by VerifyPassword if the hash cannot be decoded.
+var ErrMalformedHash = errors.New("malformed hash")
+
+// The supported password hash algorithms.
+const (
+	// BCrypt uses bcrypt with the default cost.
+	BCrypt = "bcrypt"
+
+	// PBKDF2 uses PBKDF2-SHA512 in the format of mosquitto 2.x ($7$).
+	PBKDF2 = "pbkdf2"
+
+	// Argon2 uses argon2id in the PHC string format.
+	Argon2 = "argon2"
+)
+
+// The parameters used when hashing new passwords.
+const (
+	pbkdf2Iterations = 101
+	pbkdf2SaltSize   = 12
+	pbkdf2KeySize    = 64
+	argon2Time       = 1
+	argon2Memory     = 64 * 1024
+	argon2Threads    = 4
+	argon2SaltSize   = 16
+	argon2KeySize    = 32
+)
+
+// HashPassword will hash the password using the specified algorithm.
+func HashPassword(password, algorithm string) (string, error) {
+	switch algorithm {
+	case BCrypt:
+		// generate hash
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hash), nil
+	case PBKDF2:
+		// generate salt
+		salt, err := randomBytes(pbkdf2SaltSize)
+		if err != nil {
+			return "", err
+		}
+
+		// derive key
+		key := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, pbkdf2KeySize, sha512.New)
+
+		return fmt.Sprintf("$7$%d$%s$%s", pbkdf2Iterations,
+			base64.StdEncoding.EncodeToString(salt),
+			base64.StdEncoding.EncodeToString(key)), nil
+	case Argon2:
+		// generate salt
+		salt, err := randomBytes(argon2SaltSize)
+		if err != nil {
+			return "", err
+		}
+
+		// derive key
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeySize)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+
+	return "", ErrUnsupportedHash
+}
+
+// VerifyPassword will verify the password against the specified hash. It
+// supports bcrypt, argon2id and the mosquitto SHA512 ($6$) and PBKDF2-SHA512
+// ($7$) formats. The comparison is performed in constant time.
+func VerifyPassword(hash, password string) (bool, error) {
+	// handle bcrypt
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		} else if err != nil {
+			return false, ErrMalformedHash
+		}
+
+		return true, nil
+	}
+
+	// split hash
+	parts := strings.Split(hash, "$")
+	if len(parts) < 4 || parts[0] != "" {
+		return false, ErrMalformedHash
+	}
+
+	switch parts[1] {
+	case "6":
+		// decode salt and hash
+		if len(parts) != 4 {
+			return false, ErrMalformedHash
+		}
+		salt, err1 := base64.StdEncoding.DecodeString(parts[2])
+		key, err2 := base64.StdEncoding.DecodeString(parts[3])
+		if err1 != nil || err2 != nil {
+			return false, ErrMalformedHash
+		}
+
+		// compute hash
+		sum := sha512.Sum512(append([]byte(password), salt...))
+
+		return subtle.ConstantTimeCompare(sum[:], key) == 1, nil
+	case "7":
+		// decode iterations, salt and hash
+		if len(parts) != 5 {
+			return false, ErrMalformedHash
+		}
+		iterations, err1 := strconv.Atoi(parts[2])
+		salt, err2 := base64.StdEncoding.DecodeString(parts[3])
+		key, err3 := base64.StdEncoding.DecodeString(parts[4])
+		if err1 != nil || err2 != nil || err3 != nil || iterations <= 0 {
+			return false, ErrMalformedHash
+		}
+
+		// derive key
+		derived := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha512.New)
+
+		return subtle.ConstantTimeCompare(derived, key) == 1, nil
+	case "argon2id":
+		// decode parameters, salt and hash
+		if len(parts) != 6 {
+			return false, ErrMalformedHash
+		}
+		var version int
+		var memory, time uint32
+		var threads uint8
+		_, err1 := fmt.Sscanf(parts[2], "v=%d", &version)
+		_, err2 := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+		salt, err3 := base64.RawStdEncoding.DecodeString(parts[4])
+		key, err4 := base64.RawStdEncoding.DecodeString(parts[5])
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || version != argon2.Version || time == 0 || threads == 0 {
+			return false, ErrMalformedHash
+		}
+
+		// derive key
+		derived := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(derived, key) == 1, nil
+	}
+
+	return false, ErrUnsupportedHash
+}
+
+func randomBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/tomb.v2"
+)
+
+// ErrMalformedPasswordFile is returned when a password file contains invalid
+// lines.
+var ErrMalformedPasswordFile = errors.New("malformed password file")
+
+// A PasswordFile is a CredentialStore that verifies credentials against the
+// hashes stored in a mosquitto compatible password file. Each line of the file
+// holds a username and a hash separated by a colon.
+type PasswordFile struct {
+	path    string
+	hashes  map[string]string
+	modTime time.Time
+	size    int64
+
+	mutex sync.RWMutex
+	tomb  *tomb.Tomb
+}
+
+// LoadPasswordFile will load the password file from the specified path.
+func LoadPasswordFile(path string) (*PasswordFile, error) {
+	f := &PasswordFile{
+		path: path,
+	}
+
+	// load file
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Verify will verify the password against the stored hash of the user.
+func (f *PasswordFile) Verify(user, password string) (bool, error) {
+	f.mutex.RLock()
+	hash, ok := f.hashes[user]
+	f.mutex.RUnlock()
+
+	// check user
+	if !ok {
+		return false, nil
+	}
+
+	return VerifyPassword(hash, password)
+}
+
+// Users will return a sorted list of all users.
+func (f *PasswordFile) Users() []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	// collect users
+	users := make([]string, 0, len(f.hashes))
+	for user := range f.hashes {
+		users = append(users, user)
+	}
+
+	// sort users
+	sort.Strings(users)
+
+	return users
+}
+
+// Reload will read and parse the file again. The previously loaded hashes are
+// kept if the file cannot be loaded.
+func (f *PasswordFile) Reload() error {
+	// open file
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	// get info
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// parse file
+	hashes, err := ParsePasswords(file)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// set hashes
+	f.hashes = hashes
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
+
+// Watch will check the file for changes in the specified interval and reload
+// it if it has been modified. Errors encountered while reloading are passed to
+// the optional callback.
+func (f *PasswordFile) Watch(interval time.Duration, callback func(error)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// check if already watching
+	if f.tomb != nil {
+		return
+	}
+
+	// create tomb
+	f.tomb = new(tomb.Tomb)
+	t := f.tomb
+
+	// start watcher
+	t.Go(func() error {
+		for {
+			select {
+			case <-t.Dying():
+				return tomb.ErrDying
+			case <-time.After(interval):
+			}
+
+			// reload if changed
+			err := f.reloadIfChanged()
+			if err != nil && callback != nil {
+				callback(err)
+			}
+		}
+	})
+}
+
+// Close will stop watching the file.
+func (f *PasswordFile) Close() {
+	f.mutex.Lock()
+	t := f.tomb
+	f.tomb = nil
+	f.mutex.Unlock()
+
+	// stop watcher
+	if t != nil {
+		t.Kill(nil)
+		t.Wait()
+	}
+}
+
+func (f *PasswordFile) reloadIfChanged() error {
+	// get info
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	// check info
+	f.mutex.RLock()
+	changed := !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+	f.mutex.RUnlock()
+
+	// reload if changed
+	if changed {
+		return f.Reload()
+	}
+
+	return nil
+}
+
+// ParsePasswords will parse the usernames and hashes from the specified
+// reader. Empty lines and lines starting with a hash are ignored.
+func ParsePasswords(r io.Reader) (map[string]string, error) {
+	// prepare map
+	hashes := make(map[string]string)
+
+	// read lines
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// split line
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, ErrMalformedPasswordFile
+		}
+
+		// add hash
+		hashes[line[:i]] = line[i+1:]
+	}
+
+	// check error
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// WritePasswords will atomically write the usernames and hashes to the file at
+// the specified path.
+func WritePasswords(path string, hashes map[string]string) error {
+	// sort users
+	users := make([]string, 0, len(hashes))
+	for user := range hashes {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	// prepare content
+	var buf bytes.Buffer
+	for _, user := range users {
+		fmt.Fprintf(&buf, "%s:%s\n", user, hashes[user])
+	}
+
+	// write temporary file
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// replace file
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,149 @@
+package broker
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempPasswordFile(t *testing.T, hashes map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "passwd")
+	require.NoError(t, WritePasswords(path, hashes))
+
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestParsePasswords(t *testing.T) {
+	hashes, err := ParsePasswords(strings.NewReader("# comment\n\nfoo:$7$bar\n bar:baz \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"foo": "$7$bar",
+		"bar": "baz",
+	}, hashes)
+
+	hashes, err = ParsePasswords(strings.NewReader("foo\n"))
+	assert.Equal(t, ErrMalformedPasswordFile, err)
+	assert.Nil(t, hashes)
+
+	hashes, err = ParsePasswords(strings.NewReader(":foo\n"))
+	assert.Equal(t, ErrMalformedPasswordFile, err)
+	assert.Nil(t, hashes)
+}
+
+func TestPasswordFile(t *testing.T) {
+	hash1, err := HashPassword("secret1", PBKDF2)
+	require.NoError(t, err)
+
+	path, cleanup := tempPasswordFile(t, map[string]string{
+		"user1": hash1,
+	})
+	defer cleanup()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	file, err := LoadPasswordFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1"}, file.Users())
+
+	ok, err := file.Verify("user1", "secret1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = file.Verify("user1", "foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = file.Verify("user2", "secret2")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	file.Watch(10*time.Millisecond, func(err error) {
+		assert.NoError(t, err)
+	})
+
+	hash2, err := HashPassword("secret2", BCrypt)
+	require.NoError(t, err)
+
+	require.NoError(t, WritePasswords(path, map[string]string{
+		"user1": hash1,
+		"user2": hash2,
+	}))
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		ok, _ = file.Verify("user2", "secret2")
+		if ok {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(t, ok)
+
+	assert.Equal(t, []string{"user1", "user2"}, file.Users())
+
+	file.Close()
+
+	_, err = LoadPasswordFile(path + ".missing")
+	assert.Error(t, err)
+}
+
+func TestMemoryBackendCredentialStore(t *testing.T) {
+	hash, err := HashPassword("secret", PBKDF2)
+	require.NoError(t, err)
+
+	path, cleanup := tempPasswordFile(t, map[string]string{
+		"user": hash,
+	})
+	defer cleanup()
+
+	file, err := LoadPasswordFile(path)
+	require.NoError(t, err)
+
+	backend := NewMemoryBackend()
+	backend.CredentialStore = file
+
+	port, quit, done := Run(NewEngineWithBackend(backend), "tcp")
+
+	for password, code := range map[string]packet.ConnackCode{
+		"secret": packet.ConnectionAccepted,
+		"foo":    packet.ErrNotAuthorized,
+	} {
+		u := url.URL{
+			Scheme: "tcp",
+			Host:   "localhost:" + port,
+			User:   url.UserPassword("user", password),
+		}
+
+		c := client.New()
+
+		cf, err := c.Connect(client.NewConfig(u.String()))
+		require.NoError(t, err)
+		cf.Wait(10 * time.Second)
+		assert.Equal(t, code, cf.ReturnCode())
+
+		if code == packet.ConnectionAccepted {
+			assert.NoError(t, c.Disconnect())
+		}
+	}
+
+	close(quit)
+	safeReceive(done)
+}
@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	for _, algorithm := range []string{BCrypt, PBKDF2, Argon2} {
+		hash, err := HashPassword("secret", algorithm)
+		require.NoError(t, err, algorithm)
+
+		ok, err := VerifyPassword(hash, "secret")
+		assert.NoError(t, err, algorithm)
+		assert.True(t, ok, algorithm)
+
+		ok, err = VerifyPassword(hash, "foo")
+		assert.NoError(t, err, algorithm)
+		assert.False(t, ok, algorithm)
+	}
+
+	hash, err := HashPassword("secret", "foo")
+	assert.Equal(t, ErrUnsupportedHash, err)
+	assert.Empty(t, hash)
+}
+
+func TestVerifyPasswordMosquitto(t *testing.T) {
+	salt := []byte("0123456789ab")
+	sum := sha512.Sum512(append([]byte("secret"), salt...))
+
+	hash := "$6$" + base64.StdEncoding.EncodeToString(salt) + "$" + base64.StdEncoding.EncodeToString(sum[:])
+
+	ok, err := VerifyPassword(hash, "secret")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword(hash, "foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	hash, err = HashPassword("secret", PBKDF2)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$7\$101\$[A-Za-z0-9+/=]+\$[A-Za-z0-9+/=]+$`, hash)
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"foo",
+		"$6$foo",
+		"$6$!!!$!!!",
+		"$7$foo$AAAA$AAAA",
+		"$argon2id$v=19$m=1,t=0,p=1$AAAA$AAAA",
+		"$2a$foo",
+	} {
+		ok, err := VerifyPassword(hash, "secret")
+		assert.Equal(t, ErrMalformedHash, err, hash)
+		assert.False(t, ok, hash)
+	}
+
+	ok, err := VerifyPassword("$1$foo$bar", "secret")
+	assert.Equal(t, ErrUnsupportedHash, err)
+	assert.False(t, ok)
+}
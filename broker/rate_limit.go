@@ -0,0 +1,269 @@
+package broker
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrRateLimitExceeded is returned when a client is disconnected because it
+// exceeded a rate limit.
+var ErrRateLimitExceeded = errors.New("rate limit exceeded")
+
+// A RateLimitAction defines how the broker reacts to a client that exceeds a
+// rate limit.
+type RateLimitAction int
+
+const (
+	// RateLimitDelay will delay reading from the client until enough tokens
+	// are available.
+	RateLimitDelay RateLimitAction = iota
+
+	// RateLimitDrop will acknowledge but drop messages that exceed the limit.
+	RateLimitDrop
+
+	// RateLimitDisconnect will close the client.
+	RateLimitDisconnect
+)
+
+// A RateLimit configures token buckets for the number of messages and bytes
+// that can be published per second. A zero rate disables the respective
+// bucket. If the burst is zero, the rate is used as the burst.
+type RateLimit struct {
+	// The allowed messages per second and the bucket size.
+	MessageRate  float64
+	MessageBurst float64
+
+	// The allowed payload bytes per second and the bucket size.
+	ByteRate  float64
+	ByteBurst float64
+
+	// The action taken when the limit is exceeded.
+	Action RateLimitAction
+}
+
+// A RateLimiter configures the rate limits applied to published messages.
+//
+// Each client gets its own set of buckets. The client limit is selected by
+// looking up the client id, then the username and finally falling back to the
+// default limit. Additionally, every topic limit whose pattern matches the
+// topic of a message is applied using a separate bucket per client.
+//
+// The configuration must not be modified after the engine has been started.
+type RateLimiter struct {
+	// The default limit applied to all clients.
+	Default *RateLimit
+
+	// Limits applied to specific client ids.
+	ClientIDs map[string]*RateLimit
+
+	// Limits applied to specific usernames.
+	Usernames map[string]*RateLimit
+
+	// Limits applied to topic patterns that may contain wildcards.
+	Topics map[string]*RateLimit
+}
+
+// NewRateLimiter returns a new RateLimiter that applies the default limit.
+func NewRateLimiter(def *RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Default:   def,
+		ClientIDs: make(map[string]*RateLimit),
+		Usernames: make(map[string]*RateLimit),
+		Topics:    make(map[string]*RateLimit),
+	}
+}
+
+func (r *RateLimiter) clientLimit(clientID, username string) *RateLimit {
+	// check client id
+	if limit, ok := r.ClientIDs[clientID]; ok {
+		return limit
+	}
+
+	// check username
+	if limit, ok := r.Usernames[username]; ok {
+		return limit
+	}
+
+	return r.Default
+}
+
+// a rateLimitState holds the buckets of a single client
+type rateLimitState struct {
+	limiter *RateLimiter
+	client  *rateLimitBuckets
+	topics  map[string]*rateLimitBuckets
+	dropped map[packet.ID]bool
+}
+
+func newRateLimitState(limiter *RateLimiter, clientID, username string, now time.Time) *rateLimitState {
+	s := &rateLimitState{
+		limiter: limiter,
+		topics:  make(map[string]*rateLimitBuckets),
+		dropped: make(map[packet.ID]bool),
+	}
+
+	// prepare client buckets
+	if limit := limiter.clientLimit(clientID, username); limit != nil {
+		s.client = newRateLimitBuckets(limit, now)
+	}
+
+	return s
+}
+
+// check will return whether the message exceeds a limit, the most restrictive
+// action of the exceeded limits and the time to wait before reading again.
+func (s *rateLimitState) check(msg *packet.Message, now time.Time) (bool, RateLimitAction, time.Duration) {
+	// collect buckets
+	var buckets []*rateLimitBuckets
+	if s.client != nil {
+		buckets = append(buckets, s.client)
+	}
+
+	// collect matching topic patterns in a stable order
+	var patterns []string
+	for pattern := range s.limiter.Topics {
+		if covers(pattern, msg.Topic) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+
+	// get or create topic buckets
+	for _, pattern := range patterns {
+		b, ok := s.topics[pattern]
+		if !ok {
+			b = newRateLimitBuckets(s.limiter.Topics[pattern], now)
+			s.topics[pattern] = b
+		}
+
+		buckets = append(buckets, b)
+	}
+
+	// check buckets
+	exceeded := false
+	action := RateLimitDelay
+	for _, b := range buckets {
+		if !b.available(len(msg.Payload), now) {
+			exceeded = true
+
+			// keep most restrictive action
+			if b.limit.Action > action {
+				action = b.limit.Action
+			}
+		}
+	}
+
+	// dropped and rejected messages do not consume tokens
+	if exceeded && action != RateLimitDelay {
+		return true, action, 0
+	}
+
+	// consume tokens
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.take(len(msg.Payload), now); d > wait {
+			wait = d
+		}
+	}
+
+	return exceeded, action, wait
+}
+
+// the message and byte buckets of a single limit
+type rateLimitBuckets struct {
+	limit    *RateLimit
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newRateLimitBuckets(limit *RateLimit, now time.Time) *rateLimitBuckets {
+	return &rateLimitBuckets{
+		limit:    limit,
+		messages: newTokenBucket(limit.MessageRate, limit.MessageBurst, now),
+		bytes:    newTokenBucket(limit.ByteRate, limit.ByteBurst, now),
+	}
+}
+
+// available will return whether both buckets have enough tokens available
+func (b *rateLimitBuckets) available(size int, now time.Time) bool {
+	return b.messages.available(1, now) && b.bytes.available(float64(size), now)
+}
+
+// take will consume the tokens and return the time until the buckets are no
+// longer in debt
+func (b *rateLimitBuckets) take(size int, now time.Time) time.Duration {
+	d1 := b.messages.take(1, now)
+	d2 := b.bytes.take(float64(size), now)
+
+	if d1 > d2 {
+		return d1
+	}
+
+	return d2
+}
+
+// a tokenBucket refills at the configured rate up to the burst size
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	// default burst to rate
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	// add tokens for elapsed time
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	b.last = now
+}
+
+func (b *tokenBucket) available(n float64, now time.Time) bool {
+	// check if disabled
+	if b.rate <= 0 {
+		return true
+	}
+
+	b.refill(now)
+
+	// allow a single large request on a full bucket
+	return b.tokens >= n || b.tokens >= b.burst
+}
+
+// take will consume the tokens and return the time until the bucket is no
+// longer in debt
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	// check if disabled
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.refill(now)
+	b.tokens -= n
+
+	// check debt
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+
+	b := newTokenBucket(10, 2, now)
+	assert.True(t, b.available(1, now))
+	assert.Equal(t, time.Duration(0), b.take(1, now))
+	assert.Equal(t, time.Duration(0), b.take(1, now))
+	assert.False(t, b.available(1, now))
+	assert.Equal(t, 100*time.Millisecond, b.take(1, now))
+
+	now = now.Add(200 * time.Millisecond)
+	assert.True(t, b.available(1, now))
+
+	b = newTokenBucket(0, 0, now)
+	assert.True(t, b.available(1000, now))
+	assert.Equal(t, time.Duration(0), b.take(1000, now))
+}
+
+func TestRateLimitState(t *testing.T) {
+	limiter := NewRateLimiter(&RateLimit{
+		MessageRate: 2,
+		Action:      RateLimitDelay,
+	})
+	limiter.ClientIDs["c1"] = &RateLimit{
+		ByteRate: 10,
+		Action:   RateLimitDisconnect,
+	}
+	limiter.Topics["foo/+"] = &RateLimit{
+		MessageRate: 1,
+		Action:      RateLimitDrop,
+	}
+
+	now := time.Now()
+	msg := &packet.Message{Topic: "bar", Payload: []byte("x")}
+
+	s := newRateLimitState(limiter, "c2", "", now)
+
+	exceeded, _, wait := s.check(msg, now)
+	assert.False(t, exceeded)
+	assert.Equal(t, time.Duration(0), wait)
+
+	exceeded, _, _ = s.check(msg, now)
+	assert.False(t, exceeded)
+
+	exceeded, action, wait := s.check(msg, now)
+	assert.True(t, exceeded)
+	assert.Equal(t, RateLimitDelay, action)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	msg = &packet.Message{Topic: "foo/bar", Payload: []byte("x")}
+	now = now.Add(2 * time.Second)
+
+	exceeded, _, _ = s.check(msg, now)
+	assert.False(t, exceeded)
+
+	exceeded, action, wait = s.check(msg, now)
+	assert.True(t, exceeded)
+	assert.Equal(t, RateLimitDrop, action)
+	assert.Equal(t, time.Duration(0), wait)
+
+	s = newRateLimitState(limiter, "c1", "", now)
+	msg = &packet.Message{Topic: "bar", Payload: make([]byte, 8)}
+
+	exceeded, _, _ = s.check(msg, now)
+	assert.False(t, exceeded)
+
+	exceeded, action, _ = s.check(msg, now)
+	assert.True(t, exceeded)
+	assert.Equal(t, RateLimitDisconnect, action)
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	engine := NewEngine()
+	engine.RateLimiter = NewRateLimiter(&RateLimit{
+		MessageRate: 1,
+		Action:      RateLimitDrop,
+	})
+
+	dropped := make(chan struct{}, 10)
+	engine.Logger = func(event LogEvent, c *Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+		if event == MessageDropped {
+			dropped <- struct{}{}
+		}
+	}
+
+	port, quit, done := Run(engine, "tcp")
+
+	received := make(chan *packet.Message, 10)
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		if msg != nil {
+			received <- msg
+		}
+
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	require.NoError(t, err)
+	require.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("test", 2)
+	require.NoError(t, err)
+	require.NoError(t, sf.Wait(10*time.Second))
+
+	for qos := 0; qos <= 2; qos++ {
+		pf, err := c.Publish("test", []byte("test"), uint8(qos), false)
+		require.NoError(t, err)
+		require.NoError(t, pf.Wait(10*time.Second))
+	}
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, uint8(0), msg.QOS)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+
+	safeReceive(dropped)
+	safeReceive(dropped)
+
+	assert.Empty(t, received)
+
+	assert.NoError(t, c.Disconnect())
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	engine := NewEngine()
+	engine.RateLimiter = NewRateLimiter(nil)
+	engine.RateLimiter.Topics["test/#"] = &RateLimit{
+		MessageRate: 1,
+		Action:      RateLimitDisconnect,
+	}
+
+	port, quit, done := Run(engine, "tcp")
+
+	closed := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Error(t, err)
+		close(closed)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	require.NoError(t, err)
+	require.NoError(t, cf.Wait(10*time.Second))
+
+	pf, err := c.Publish("foo", []byte("test"), 1, false)
+	require.NoError(t, err)
+	require.NoError(t, pf.Wait(10*time.Second))
+
+	pf, err = c.Publish("test/foo", []byte("test"), 1, false)
+	require.NoError(t, err)
+	require.NoError(t, pf.Wait(10*time.Second))
+
+	_, err = c.Publish("test/foo", []byte("test"), 0, false)
+	require.NoError(t, err)
+
+	safeReceive(closed)
+
+	close(quit)
+	safeReceive(done)
+}
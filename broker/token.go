@@ -0,0 +1,264 @@
+package broker
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+type tokenClient struct {
+	publish   *topic.Tree
+	subscribe []string
+	timer     *time.Timer
+}
+
+// A TokenAuthenticator wraps a Backend and authenticates clients using a JWT
+// that is passed as the password. The claims of the token are mapped to the
+// topic patterns a client is allowed to publish and subscribe to. Clients are
+// closed when their token expires while being connected.
+//
+// Publishes to topics that are not allowed are silently dropped, while denied
+// subscriptions are marked as failed in the SubackPacket.
+type TokenAuthenticator struct {
+	Backend
+
+	// Keys is the list of keys used to verify the tokens.
+	Keys []*JWTKey
+
+	// Audience is the required audience of the tokens if set.
+	Audience string
+
+	// Issuer is the required issuer of the tokens if set.
+	Issuer string
+
+	// Leeway is the allowed clock skew when checking the expiration.
+	Leeway time.Duration
+
+	// PublishClaim is the name of the claim that holds the topic patterns
+	// the client is allowed to publish to. The default is "publish".
+	PublishClaim string
+
+	// SubscribeClaim is the name of the claim that holds the topic patterns
+	// the client is allowed to subscribe to. The default is "subscribe".
+	SubscribeClaim string
+
+	// MapUsername will replace the username of the client with the subject
+	// of the token.
+	MapUsername bool
+
+	// RequireExpiration will deny tokens that have no expiration.
+	RequireExpiration bool
+
+	clients map[*Client]*tokenClient
+	mutex   sync.Mutex
+}
+
+// NewTokenAuthenticator returns a new TokenAuthenticator that wraps the
+// specified Backend and verifies tokens using the specified keys.
+func NewTokenAuthenticator(backend Backend, keys ...*JWTKey) *TokenAuthenticator {
+	return &TokenAuthenticator{
+		Backend:        backend,
+		Keys:           keys,
+		PublishClaim:   "publish",
+		SubscribeClaim: "subscribe",
+		clients:        make(map[*Client]*tokenClient),
+	}
+}
+
+// Authenticate will verify the token passed as the password and set up the
+// permissions of the client.
+func (a *TokenAuthenticator) Authenticate(client *Client, user, password string) (bool, error) {
+	// verify token
+	claims, err := VerifyJWT(password, a.Keys)
+	if err != nil {
+		return false, nil
+	}
+
+	// get current time
+	now := time.Now()
+
+	// check expiration
+	exp, ok := claims.Time("exp")
+	if ok && !now.Before(exp.Add(a.Leeway)) {
+		return false, nil
+	} else if !ok && a.RequireExpiration {
+		return false, nil
+	}
+
+	// check not before
+	nbf, ok := claims.Time("nbf")
+	if ok && now.Add(a.Leeway).Before(nbf) {
+		return false, nil
+	}
+
+	// check audience
+	if a.Audience != "" && !contains(claims.Strings("aud"), a.Audience) {
+		return false, nil
+	}
+
+	// check issuer
+	if a.Issuer != "" && claims.String("iss") != a.Issuer {
+		return false, nil
+	}
+
+	// map username
+	if a.MapUsername {
+		client.username = claims.String("sub")
+	}
+
+	// prepare permissions
+	tc := &tokenClient{
+		publish:   topic.NewTree(),
+		subscribe: claims.Strings(a.SubscribeClaim),
+	}
+
+	// add publish patterns
+	for _, pattern := range claims.Strings(a.PublishClaim) {
+		tc.publish.Add(pattern, true)
+	}
+
+	// close client when the token expires
+	if !exp.IsZero() {
+		tc.timer = time.AfterFunc(exp.Add(a.Leeway).Sub(now), func() {
+			client.Close(false)
+		})
+	}
+
+	// save client
+	a.mutex.Lock()
+	a.clients[client] = tc
+	a.mutex.Unlock()
+
+	return true, nil
+}
+
+// Subscribe will return ErrSubscriptionDenied if the client is not allowed to
+// subscribe to the topic and otherwise forward the call to the wrapped Backend.
+func (a *TokenAuthenticator) Subscribe(client *Client, sub *packet.Subscription) error {
+	// check permission
+	tc := a.get(client)
+	if tc == nil || !coveredBy(sub.Topic, tc.subscribe) {
+		return ErrSubscriptionDenied
+	}
+
+	return a.Backend.Subscribe(client, sub)
+}
+
+// StoreRetained will ignore the message if the client is not allowed to
+// publish to the topic and otherwise forward the call to the wrapped Backend.
+func (a *TokenAuthenticator) StoreRetained(client *Client, msg *packet.Message) error {
+	if !a.canPublish(client, msg.Topic) {
+		return nil
+	}
+
+	return a.Backend.StoreRetained(client, msg)
+}
+
+// ClearRetained will ignore the call if the client is not allowed to publish
+// to the topic and otherwise forward the call to the wrapped Backend.
+func (a *TokenAuthenticator) ClearRetained(client *Client, topic string) error {
+	if !a.canPublish(client, topic) {
+		return nil
+	}
+
+	return a.Backend.ClearRetained(client, topic)
+}
+
+// Publish will drop the message if the client is not allowed to publish to the
+// topic and otherwise forward the call to the wrapped Backend.
+func (a *TokenAuthenticator) Publish(client *Client, msg *packet.Message) error {
+	if !a.canPublish(client, msg.Topic) {
+		return nil
+	}
+
+	return a.Backend.Publish(client, msg)
+}
+
+// Terminate will remove the permissions of the client and forward the call to
+// the wrapped Backend.
+func (a *TokenAuthenticator) Terminate(client *Client) error {
+	a.mutex.Lock()
+
+	// stop timer and remove client
+	if tc, ok := a.clients[client]; ok {
+		if tc.timer != nil {
+			tc.timer.Stop()
+		}
+
+		delete(a.clients, client)
+	}
+
+	a.mutex.Unlock()
+
+	return a.Backend.Terminate(client)
+}
+
+func (a *TokenAuthenticator) get(client *Client) *tokenClient {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.clients[client]
+}
+
+func (a *TokenAuthenticator) canPublish(client *Client, topic string) bool {
+	tc := a.get(client)
+	return tc != nil && tc.publish.MatchFirst(topic) != nil
+}
+
+// checks if the filter is covered by one of the patterns
+func coveredBy(filter string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if covers(pattern, filter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks if every topic matched by the filter is also matched by the pattern
+func covers(pattern, filter string) bool {
+	p := strings.Split(pattern, "/")
+	f := strings.Split(filter, "/")
+
+	for i, segment := range p {
+		// a multi level wildcard covers the rest
+		if segment == "#" {
+			return true
+		}
+
+		// check remaining filter
+		if i >= len(f) {
+			return false
+		}
+
+		// a single level wildcard covers any segment except a multi level one
+		if segment == "+" {
+			if f[i] == "#" {
+				return false
+			}
+
+			continue
+		}
+
+		// other segments must match exactly
+		if segment != f[i] {
+			return false
+		}
+	}
+
+	return len(p) == len(f)
+}
+
+func contains(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+
+	return false
+}
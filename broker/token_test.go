@@ -0,0 +1,212 @@
+package broker
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenConfig(port, token string) *client.Config {
+	u := url.URL{
+		Scheme: "tcp",
+		Host:   "localhost:" + port,
+		User:   url.UserPassword("device1", token),
+	}
+
+	config := client.NewConfig(u.String())
+	config.ValidateSubs = false
+
+	return config
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+
+	auth := NewTokenAuthenticator(NewMemoryBackend(), &JWTKey{Key: secret})
+	auth.Audience = "broker"
+
+	port, quit, done := Run(NewEngineWithBackend(auth), "tcp")
+
+	token := makeJWT("HS256", "", secret, map[string]interface{}{
+		"sub":       "device1",
+		"aud":       "broker",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"publish":   []string{"devices/device1/#"},
+		"subscribe": []string{"devices/device1/#", "global/+"},
+	})
+
+	received := make(chan *packet.Message, 10)
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		if msg != nil {
+			received <- msg
+		}
+
+		return nil
+	}
+
+	cf, err := c.Connect(tokenConfig(port, token))
+	require.NoError(t, err)
+	require.NoError(t, cf.Wait(10*time.Second))
+	assert.Equal(t, packet.ConnectionAccepted, cf.ReturnCode())
+
+	sf, err := c.SubscribeMultiple([]packet.Subscription{
+		{Topic: "devices/device1/+", QOS: 0},
+		{Topic: "global/foo", QOS: 0},
+		{Topic: "global/#", QOS: 0},
+		{Topic: "devices/device2/foo", QOS: 0},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, []uint8{0, 0, packet.QOSFailure, packet.QOSFailure}, sf.ReturnCodes())
+
+	pf, err := c.Publish("devices/device2/foo", []byte("denied"), 1, false)
+	require.NoError(t, err)
+	require.NoError(t, pf.Wait(10*time.Second))
+
+	pf, err = c.Publish("devices/device1/foo", []byte("allowed"), 1, false)
+	require.NoError(t, err)
+	require.NoError(t, pf.Wait(10*time.Second))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "devices/device1/foo", msg.Topic)
+		assert.Equal(t, []byte("allowed"), msg.Payload)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+
+	assert.NoError(t, c.Disconnect())
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTokenAuthenticatorInvalidToken(t *testing.T) {
+	secret := []byte("secret")
+
+	auth := NewTokenAuthenticator(NewMemoryBackend(), &JWTKey{Key: secret})
+	auth.Audience = "broker"
+	auth.RequireExpiration = true
+
+	port, quit, done := Run(NewEngineWithBackend(auth), "tcp")
+
+	for _, token := range []string{
+		"foo",
+		makeJWT("HS256", "", []byte("foo"), map[string]interface{}{
+			"aud": "broker",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}),
+		makeJWT("HS256", "", secret, map[string]interface{}{
+			"aud": "broker",
+			"exp": time.Now().Add(-time.Hour).Unix(),
+		}),
+		makeJWT("HS256", "", secret, map[string]interface{}{
+			"aud": "other",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}),
+		makeJWT("HS256", "", secret, map[string]interface{}{
+			"aud": "broker",
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"nbf": time.Now().Add(time.Minute).Unix(),
+		}),
+		makeJWT("HS256", "", secret, map[string]interface{}{
+			"aud": "broker",
+		}),
+	} {
+		c := client.New()
+
+		cf, err := c.Connect(tokenConfig(port, token))
+		require.NoError(t, err)
+		cf.Wait(10 * time.Second)
+		assert.Equal(t, packet.ErrNotAuthorized, cf.ReturnCode())
+	}
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTokenAuthenticatorExpiration(t *testing.T) {
+	secret := []byte("secret")
+
+	auth := NewTokenAuthenticator(NewMemoryBackend(), &JWTKey{Key: secret})
+
+	port, quit, done := Run(NewEngineWithBackend(auth), "tcp")
+
+	token := makeJWT("HS256", "", secret, map[string]interface{}{
+		"exp": time.Now().Add(1100 * time.Millisecond).Unix(),
+	})
+
+	closed := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Error(t, err)
+		close(closed)
+		return nil
+	}
+
+	cf, err := c.Connect(tokenConfig(port, token))
+	require.NoError(t, err)
+	require.NoError(t, cf.Wait(10*time.Second))
+	assert.Equal(t, packet.ConnectionAccepted, cf.ReturnCode())
+
+	safeReceive(closed)
+
+	close(quit)
+	safeReceive(done)
+}
+
+type failingSetupBackend struct {
+	*MemoryBackend
+}
+
+func (b *failingSetupBackend) Setup(client *Client, id string) (Session, bool, error) {
+	return nil, false, errors.New("setup failed")
+}
+
+func TestTokenAuthenticatorRejected(t *testing.T) {
+	secret := []byte("secret")
+
+	auth := NewTokenAuthenticator(&failingSetupBackend{NewMemoryBackend()}, &JWTKey{Key: secret})
+
+	port, quit, done := Run(NewEngineWithBackend(auth), "tcp")
+
+	token := makeJWT("HS256", "", secret, map[string]interface{}{
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	c := client.New()
+
+	cf, err := c.Connect(tokenConfig(port, token))
+	require.NoError(t, err)
+	assert.Equal(t, future.ErrCanceled, cf.Wait(10*time.Second))
+
+	auth.mutex.Lock()
+	assert.Empty(t, auth.clients)
+	auth.mutex.Unlock()
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestCovers(t *testing.T) {
+	assert.True(t, covers("a/#", "a"))
+	assert.True(t, covers("a/#", "a/b/c"))
+	assert.True(t, covers("a/#", "a/+/#"))
+	assert.True(t, covers("a/+", "a/b"))
+	assert.True(t, covers("a/+", "a/+"))
+	assert.True(t, covers("#", "#"))
+	assert.False(t, covers("a/+", "a/#"))
+	assert.False(t, covers("a/+", "a/b/c"))
+	assert.False(t, covers("a/b", "a/+"))
+	assert.False(t, covers("a/b/c", "a/b"))
+}
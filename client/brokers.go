@@ -0,0 +1,127 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// A BrokerStrategy defines the order in which the Service tries the configured
+// broker URLs.
+type BrokerStrategy int
+
+const (
+	// Failover will always try the first healthy broker in the configured
+	// order.
+	Failover BrokerStrategy = iota
+
+	// RoundRobin will try the next healthy broker after the one used for the
+	// last attempt.
+	RoundRobin
+
+	// Random will try a random healthy broker.
+	Random
+)
+
+// the health of a single broker
+type brokerState struct {
+	url      string
+	delay    time.Duration
+	failures int
+	retry    time.Time
+}
+
+// a brokerPool selects brokers and tracks their health
+type brokerPool struct {
+	strategy  BrokerStrategy
+	reconnect ReconnectStrategy
+	brokers   []*brokerState
+	next      int
+	rand      *rand.Rand
+}
+
+func newBrokerPool(urls []string, strategy BrokerStrategy, reconnect ReconnectStrategy) *brokerPool {
+	p := &brokerPool{
+		strategy:  strategy,
+		reconnect: reconnect,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	// prepare brokers
+	for _, url := range urls {
+		p.brokers = append(p.brokers, &brokerState{
+			url: url,
+		})
+	}
+
+	return p
+}
+
+// pick will return the broker to try next and the time to wait until it may be
+// tried. If no broker is healthy, the broker that recovers first is returned.
+func (p *brokerPool) pick(now time.Time) (*brokerState, time.Duration) {
+	// collect healthy brokers
+	var healthy []int
+	for i, b := range p.brokers {
+		if !b.retry.After(now) {
+			healthy = append(healthy, i)
+		}
+	}
+
+	// select broker that recovers first if none is healthy
+	if len(healthy) == 0 {
+		first := p.brokers[0]
+		for _, b := range p.brokers[1:] {
+			if b.retry.Before(first.retry) {
+				first = b
+			}
+		}
+
+		return first, first.retry.Sub(now)
+	}
+
+	// select healthy broker
+	var index int
+	switch p.strategy {
+	case RoundRobin:
+		index = healthy[0]
+		for _, i := range healthy {
+			if i >= p.next {
+				index = i
+				break
+			}
+		}
+
+		p.next = (index + 1) % len(p.brokers)
+	case Random:
+		index = healthy[p.rand.Intn(len(healthy))]
+	default:
+		index = healthy[0]
+	}
+
+	return p.brokers[index], 0
+}
+
+// success will mark the broker as healthy
+func (p *brokerPool) success(b *brokerState) {
+	b.delay = 0
+	b.failures = 0
+	b.retry = time.Time{}
+}
+
+// failure will mark the broker as unhealthy until the delay returned by the
+// reconnect strategy elapsed. It will return the error of the strategy if no
+// further attempts should be made.
+func (p *brokerPool) failure(b *brokerState, now time.Time, reason error) error {
+	b.failures++
+
+	// get delay
+	delay, err := p.reconnect.Next(b.failures, b.delay, reason)
+	if err != nil {
+		return err
+	}
+
+	b.delay = delay
+	b.retry = now.Add(delay)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerPoolFailover(t *testing.T) {
+	p := newBrokerPool([]string{"a", "b"}, Failover, &ExponentialBackoff{Min: time.Second, Max: 4 * time.Second})
+	now := time.Now()
+
+	b, d := p.pick(now)
+	assert.Equal(t, "a", b.url)
+	assert.Equal(t, time.Duration(0), d)
+
+	p.failure(b, now, nil)
+	assert.Equal(t, 1, b.failures)
+
+	b, d = p.pick(now)
+	assert.Equal(t, "b", b.url)
+	assert.Equal(t, time.Duration(0), d)
+
+	p.failure(b, now.Add(time.Millisecond), nil)
+
+	b, d = p.pick(now)
+	assert.Equal(t, "a", b.url)
+	assert.Equal(t, time.Second, d)
+
+	now = now.Add(time.Second)
+
+	b, d = p.pick(now)
+	assert.Equal(t, "a", b.url)
+	assert.Equal(t, time.Duration(0), d)
+
+	p.success(b)
+	assert.Equal(t, 0, b.failures)
+
+	b, _ = p.pick(now)
+	assert.Equal(t, "a", b.url)
+}
+
+func TestBrokerPoolRoundRobin(t *testing.T) {
+	p := newBrokerPool([]string{"a", "b", "c"}, RoundRobin, &ExponentialBackoff{Min: time.Second, Max: 4 * time.Second})
+	now := time.Now()
+
+	var urls []string
+	for i := 0; i < 4; i++ {
+		b, _ := p.pick(now)
+		urls = append(urls, b.url)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a"}, urls)
+
+	p.failure(p.brokers[1], now, nil)
+
+	urls = nil
+	for i := 0; i < 3; i++ {
+		b, _ := p.pick(now)
+		urls = append(urls, b.url)
+	}
+	assert.Equal(t, []string{"c", "a", "c"}, urls)
+}
+
+func TestBrokerPoolRandom(t *testing.T) {
+	p := newBrokerPool([]string{"a", "b", "c"}, Random, &ExponentialBackoff{Min: time.Second, Max: 4 * time.Second})
+	now := time.Now()
+
+	p.failure(p.brokers[0], now, nil)
+	p.failure(p.brokers[2], now, nil)
+
+	for i := 0; i < 10; i++ {
+		b, d := p.pick(now)
+		assert.Equal(t, "b", b.url)
+		assert.Equal(t, time.Duration(0), d)
+	}
+}
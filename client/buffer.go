@@ -0,0 +1,398 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrBufferFull is returned by a Buffer if it cannot hold more messages.
+var ErrBufferFull = errors.New("buffer full")
+
+// ErrInvalidBufferFile is returned by NewFileBuffer if the file is corrupted.
+var ErrInvalidBufferFile = errors.New("invalid buffer file")
+
+// A DropPolicy defines which message is dropped by the Service if the buffer is
+// full.
+type DropPolicy int
+
+const (
+	// DropNewest will drop the message that is being published.
+	DropNewest DropPolicy = iota
+
+	// DropOldest will drop the oldest buffered message to make room for the
+	// message that is being published.
+	DropOldest
+)
+
+// A Buffer stores messages in order until they are handed over to a client.
+//
+// The Service serializes calls to the buffer.
+type Buffer interface {
+	// Push appends a message to the buffer. It should return ErrBufferFull if
+	// the buffer cannot hold more messages.
+	Push(msg *packet.Message) error
+
+	// Front returns the oldest message or nil if the buffer is empty.
+	Front() (*packet.Message, error)
+
+	// Shift removes the oldest message.
+	Shift() error
+
+	// Len returns the number of buffered messages.
+	Len() int
+}
+
+// A MemoryBuffer is a Buffer that keeps the messages in memory.
+type MemoryBuffer struct {
+	size     int
+	messages []*packet.Message
+	mutex    sync.Mutex
+}
+
+// NewMemoryBuffer returns a new MemoryBuffer that holds up to size messages. A
+// size of zero or less disables the limit.
+func NewMemoryBuffer(size int) *MemoryBuffer {
+	return &MemoryBuffer{
+		size: size,
+	}
+}
+
+// Push will append a message to the buffer.
+func (b *MemoryBuffer) Push(msg *packet.Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// check size
+	if b.size > 0 && len(b.messages) >= b.size {
+		return ErrBufferFull
+	}
+
+	b.messages = append(b.messages, msg)
+
+	return nil
+}
+
+// Front will return the oldest message or nil if the buffer is empty.
+func (b *MemoryBuffer) Front() (*packet.Message, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.messages) == 0 {
+		return nil, nil
+	}
+
+	return b.messages[0], nil
+}
+
+// Shift will remove the oldest message.
+func (b *MemoryBuffer) Shift() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.messages) > 0 {
+		b.messages[0] = nil
+		b.messages = b.messages[1:]
+	}
+
+	return nil
+}
+
+// Len will return the number of buffered messages.
+func (b *MemoryBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.messages)
+}
+
+const (
+	fileBufferPush  byte = '+'
+	fileBufferShift byte = '-'
+)
+
+// A FileBuffer is a Buffer that additionally persists the messages in an
+// append only file. Messages that have been buffered when the process exited
+// are loaded again by NewFileBuffer.
+//
+// The file is compacted once it contains more removed than buffered messages.
+// An incomplete record at the end of the file that has been left by an
+// interrupted write is dropped when the file is loaded.
+//
+// Note: Writes are not synced to disk and may be lost if the system crashes.
+type FileBuffer struct {
+	path    string
+	file    *os.File
+	memory  *MemoryBuffer
+	removed int
+	mutex   sync.Mutex
+}
+
+// NewFileBuffer opens or creates the buffer file at path and returns a new
+// FileBuffer that holds up to size messages. A size of zero or less disables
+// the limit.
+func NewFileBuffer(path string, size int) (*FileBuffer, error) {
+	b := &FileBuffer{
+		path:   path,
+		memory: NewMemoryBuffer(0),
+	}
+
+	// load existing file
+	err := b.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// set size after loading to keep all persisted messages
+	b.memory.size = size
+
+	// rewrite file to drop removed messages
+	err = b.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Push will append a message to the buffer and the file.
+func (b *FileBuffer) Push(msg *packet.Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// check size
+	if b.memory.size > 0 && b.memory.Len() >= b.memory.size {
+		return ErrBufferFull
+	}
+
+	// encode record
+	buf, err := encodeFileBufferMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	// write record
+	err = b.write(buf)
+	if err != nil {
+		return err
+	}
+
+	// add to memory
+	return b.memory.Push(msg)
+}
+
+// Front will return the oldest message or nil if the buffer is empty.
+func (b *FileBuffer) Front() (*packet.Message, error) {
+	return b.memory.Front()
+}
+
+// Shift will remove the oldest message.
+func (b *FileBuffer) Shift() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// check length
+	if b.memory.Len() == 0 {
+		return nil
+	}
+
+	// write record
+	err := b.write([]byte{fileBufferShift})
+	if err != nil {
+		return err
+	}
+
+	// remove from memory
+	b.memory.Shift()
+
+	// compact file if necessary
+	b.removed++
+	if b.removed > b.memory.Len() {
+		return b.compact()
+	}
+
+	return nil
+}
+
+// Len will return the number of buffered messages.
+func (b *FileBuffer) Len() int {
+	return b.memory.Len()
+}
+
+// Close will close the underlying file.
+func (b *FileBuffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.file.Close()
+}
+
+// reads all records from the file
+func (b *FileBuffer) load() error {
+	// open file
+	file, err := os.Open(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	// read records
+	reader := bufio.NewReader(file)
+	for {
+		// read type
+		typ, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// handle shift
+		if typ == fileBufferShift {
+			b.memory.Shift()
+			continue
+		} else if typ != fileBufferPush {
+			return ErrInvalidBufferFile
+		}
+
+		// read publish packet, an incomplete record at the end of the file is
+		// left by an interrupted write and dropped by the following compaction
+		msg, err := decodeFileBufferMessage(reader)
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		b.memory.Push(msg)
+	}
+}
+
+// appends the record to the file and removes partially written data on error
+func (b *FileBuffer) write(buf []byte) error {
+	// get current size
+	info, err := b.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// write record
+	_, err = b.file.Write(buf)
+	if err != nil {
+		b.file.Truncate(info.Size())
+		return err
+	}
+
+	return nil
+}
+
+// rewrites the file with the currently buffered messages
+func (b *FileBuffer) compact() error {
+	// create temporary file
+	tmp, err := os.OpenFile(b.path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	// write messages
+	writer := bufio.NewWriter(tmp)
+	for _, msg := range b.memory.messages {
+		buf, err := encodeFileBufferMessage(msg)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+
+		writer.Write(buf)
+	}
+
+	// flush and close
+	err = writer.Flush()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	// close current file
+	if b.file != nil {
+		b.file.Close()
+	}
+
+	// replace file
+	err = os.Rename(b.path+".tmp", b.path)
+	if err != nil {
+		return err
+	}
+
+	// reopen file
+	b.file, err = os.OpenFile(b.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	// reset counter
+	b.removed = 0
+
+	return nil
+}
+
+// encodes a push record using a publish packet
+func encodeFileBufferMessage(msg *packet.Message) ([]byte, error) {
+	// prepare packet
+	pkt := packet.NewPublishPacket()
+	pkt.Message = *msg
+	if msg.QOS > 0 {
+		pkt.ID = 1
+	}
+
+	// encode packet
+	buf := make([]byte, 1+pkt.Len())
+	buf[0] = fileBufferPush
+	_, err := pkt.Encode(buf[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// decodes a publish packet following a push record
+func decodeFileBufferMessage(reader *bufio.Reader) (*packet.Message, error) {
+	// detect length
+	header, err := reader.Peek(5)
+	l, _ := packet.DetectPacket(header)
+	if l == 0 && err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if l == 0 {
+		return nil, ErrInvalidBufferFile
+	}
+
+	// read packet
+	buf := make([]byte, l)
+	_, err = io.ReadFull(reader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	// decode packet
+	pkt := packet.NewPublishPacket()
+	_, err = pkt.Decode(buf)
+	if err != nil {
+		return nil, ErrInvalidBufferFile
+	}
+
+	return &pkt.Message, nil
+}
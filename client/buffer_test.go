@@ -0,0 +1,141 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBuffer(t *testing.T) {
+	b := NewMemoryBuffer(2)
+
+	msg, err := b.Front()
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	assert.NoError(t, b.Push(&packet.Message{Topic: "1"}))
+	assert.NoError(t, b.Push(&packet.Message{Topic: "2"}))
+	assert.Equal(t, ErrBufferFull, b.Push(&packet.Message{Topic: "3"}))
+	assert.Equal(t, 2, b.Len())
+
+	msg, err = b.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", msg.Topic)
+
+	assert.NoError(t, b.Shift())
+	assert.Equal(t, 1, b.Len())
+
+	msg, err = b.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", msg.Topic)
+}
+
+func TestFileBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "buffer")
+
+	b, err := NewFileBuffer(path, 3)
+	assert.NoError(t, err)
+
+	assert.NoError(t, b.Push(&packet.Message{Topic: "1", Payload: []byte("1")}))
+	assert.NoError(t, b.Push(&packet.Message{Topic: "2", Payload: []byte("2"), QOS: 1}))
+	assert.NoError(t, b.Push(&packet.Message{Topic: "3", Payload: []byte("3"), QOS: 2, Retain: true}))
+	assert.Equal(t, ErrBufferFull, b.Push(&packet.Message{Topic: "4"}))
+	assert.NoError(t, b.Shift())
+	assert.NoError(t, b.Close())
+
+	b, err = NewFileBuffer(path, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, b.Len())
+
+	msg, err := b.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, &packet.Message{Topic: "2", Payload: []byte("2"), QOS: 1}, msg)
+
+	assert.NoError(t, b.Shift())
+
+	msg, err = b.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, &packet.Message{Topic: "3", Payload: []byte("3"), QOS: 2, Retain: true}, msg)
+
+	assert.NoError(t, b.Shift())
+	assert.NoError(t, b.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	b, err = NewFileBuffer(path, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, b.Len())
+	assert.NoError(t, b.Close())
+}
+
+func TestFileBufferIncompleteRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "buffer")
+
+	b, err := NewFileBuffer(path, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Push(&packet.Message{Topic: "1", Payload: []byte("1")}))
+	assert.NoError(t, b.Push(&packet.Message{Topic: "2", Payload: []byte("2")}))
+	assert.NoError(t, b.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	buf, err := encodeFileBufferMessage(&packet.Message{Topic: "3", Payload: []byte("3")})
+	assert.NoError(t, err)
+
+	for _, n := range []int{1, 3, len(buf) - 1} {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		assert.NoError(t, err)
+		_, err = file.Write(buf[:n])
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+
+		b, err = NewFileBuffer(path, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, b.Len())
+		assert.NoError(t, b.Close())
+
+		info2, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, info.Size(), info2.Size())
+	}
+
+	b, err = NewFileBuffer(path, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Push(&packet.Message{Topic: "3", Payload: []byte("3")}))
+	assert.NoError(t, b.Close())
+
+	b, err = NewFileBuffer(path, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, b.Len())
+	assert.NoError(t, b.Close())
+}
+
+func TestFileBufferInvalidRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "buffer")
+
+	err = ioutil.WriteFile(path, []byte("x"), 0600)
+	assert.NoError(t, err)
+
+	b, err := NewFileBuffer(path, 0)
+	assert.Equal(t, ErrInvalidBufferFile, err)
+	assert.Nil(t, b)
+}
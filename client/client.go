@@ -3,6 +3,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
@@ -45,6 +46,14 @@ var ErrClientExpectedConnack = errors.New("client expected connack")
 // failed when Config.ValidateSubs must be set to true.
 var ErrFailedSubscription = errors.New("failed subscription")
 
+// ErrClientRetransmitsExceeded is returned in the Callback if a packet has not
+// been acknowledged after the maximum number of retransmissions.
+var ErrClientRetransmitsExceeded = errors.New("client retransmits exceeded")
+
+// ErrUnknownMessage is returned by Ack if the message is not awaiting an
+// acknowledgement.
+var ErrUnknownMessage = errors.New("unknown message")
+
 // A Callback is a function called by the client upon received messages or
 // internal errors. An error can be returned if the callback is not already
 // called with an error to instantly close the client and prevent it from
@@ -92,7 +101,8 @@ type Session interface {
 // A Client connects to a broker and handles the transmission of packets. It will
 // automatically send PingreqPackets to keep the connection alive. Outgoing
 // publish related packets will be stored in session and resent when the
-// connection gets closed abruptly. All methods return Futures that get completed
+// connection gets closed abruptly or, if a RetransmitTimeout is set, when they
+// are not acknowledged in time. All methods return Futures that get completed
 // when the packets get acknowledged by the broker. Once the connection is closed
 // all waiting futures get canceled.
 //
@@ -117,10 +127,49 @@ type Client struct {
 	// automatic keep alive handler.
 	Logger Logger
 
+	// If enabled, incoming QOS 1 and 2 messages are not acknowledged after the
+	// callback returns but once they are passed to Ack. The acknowledgements
+	// are sent in the order the messages have been received.
+	//
+	// Note: The value must be changed before calling Connect.
+	ManualAck bool
+
+	// The maximum number of unacknowledged messages in manual acknowledgement
+	// mode. Once reached, the client stops reading incoming packets until a
+	// message is acknowledged. This also delays the processing of pongs and
+	// acknowledgements for outgoing messages. Zero disables the limit.
+	//
+	// Note: The value must be changed before calling Connect.
+	MaxUnacked int
+
+	// The deduplicator used to suppress redelivered QOS 1 messages.
+	Deduplicator *Deduplicator
+
+	// The duration after which unacknowledged PublishPackets and PubrelPackets
+	// are resent from the session. Resent PublishPackets have the Dup flag set.
+	// Zero disables retransmissions.
+	//
+	// Note: The value must be changed before calling Connect.
+	RetransmitTimeout time.Duration
+
+	// The maximum number of retransmissions of a single packet. If exceeded,
+	// the client is closed with ErrClientRetransmitsExceeded to trigger a
+	// reconnect. Zero disables the limit.
+	//
+	// Note: The value must be changed before calling Connect.
+	MaxRetransmits int
+
 	clean bool
 
+	acks      []*pendingAck
+	ackMutex  sync.Mutex
+	ackSignal chan struct{}
+
+	credentials   *Credentials
 	keepAlive     time.Duration
 	tracker       *tracker
+	inflight      *inflight
+	stats         *statistics
 	futureStore   *future.Store
 	connectFuture *future.Future
 
@@ -135,6 +184,9 @@ func New() *Client {
 		state:       clientInitialized,
 		Session:     session.NewMemorySession(),
 		futureStore: future.NewStore(),
+		inflight:    newInflight(),
+		stats:       newStatistics(),
+		ackSignal:   make(chan struct{}, 1),
 	}
 }
 
@@ -142,6 +194,35 @@ func New() *Client {
 // return a ConnectFuture that gets completed once a ConnackPacket has been
 // received. If the ConnectPacket couldn't be transmitted it will return an error.
 func (c *Client) Connect(config *Config) (ConnectFuture, error) {
+	return c.connect(context.Background(), config)
+}
+
+// ConnectContext opens the connection to the broker and sends a ConnectPacket.
+// In contrast to Connect, it will block until the ConnackPacket has been
+// received. The dial and the wait are aborted and the client is closed if the
+// context is canceled or its deadline is exceeded.
+func (c *Client) ConnectContext(ctx context.Context, config *Config) (ConnectFuture, error) {
+	// connect
+	connectFuture, err := c.connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// wait for connack
+	err = connectFuture.WaitContext(ctx)
+	if err != nil {
+		// close client if context is done
+		if err == ctx.Err() {
+			c.Close()
+		}
+
+		return nil, err
+	}
+
+	return connectFuture, nil
+}
+
+func (c *Client) connect(ctx context.Context, config *Config) (ConnectFuture, error) {
 	if config == nil {
 		panic("no config specified")
 	}
@@ -174,18 +255,40 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		return nil, err
 	}
 
+	// fetch credentials
+	if config.CredentialsProvider != nil {
+		c.credentials, err = config.CredentialsProvider(config.BrokerURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// allocate and initialize tracker
 	c.keepAlive = keepAlive
 	c.tracker = newTracker(keepAlive)
 
+	// get dialer
+	dialer := config.Dialer
+	if c.credentials != nil && c.credentials.TLSConfig != nil {
+		// clone dialer to replace the tls config without sharing its
+		// internal state
+		d := transport.NewDialer()
+		if dialer != nil {
+			d = dialer.Clone()
+		}
+
+		d.TLSConfig = c.credentials.TLSConfig
+		dialer = d
+	}
+
 	// dial broker (with custom dialer if present)
-	if config.Dialer != nil {
-		c.conn, err = config.Dialer.Dial(config.BrokerURL)
+	if dialer != nil {
+		c.conn, err = dialer.DialContext(ctx, config.BrokerURL)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		c.conn, err = transport.Dial(config.BrokerURL)
+		c.conn, err = transport.DialContext(ctx, config.BrokerURL)
 		if err != nil {
 			return nil, err
 		}
@@ -220,6 +323,12 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		connect.Password, _ = urlParts.User.Password()
 	}
 
+	// set fetched credentials
+	if c.credentials != nil && c.credentials.Username != "" {
+		connect.Username = c.credentials.Username
+		connect.Password = c.credentials.Password
+	}
+
 	// set will
 	connect.Will = config.WillMessage
 
@@ -255,6 +364,35 @@ func (c *Client) Publish(topic string, payload []byte, qos uint8, retain bool) (
 	return c.PublishMessage(msg)
 }
 
+// PublishContext will send a PublishPacket containing the passed parameters and
+// block until the quality of service flow has been completed or the context is
+// done.
+func (c *Client) PublishContext(ctx context.Context, topic string, payload []byte, qos uint8, retain bool) error {
+	msg := &packet.Message{
+		Topic:   topic,
+		Payload: payload,
+		QOS:     qos,
+		Retain:  retain,
+	}
+
+	return c.PublishMessageContext(ctx, msg)
+}
+
+// PublishMessageContext will send a PublishPacket containing the passed message
+// and block until the quality of service flow has been completed or the context
+// is done.
+//
+// Note: The flow is not aborted if the context is done and the message might
+// still be delivered.
+func (c *Client) PublishMessageContext(ctx context.Context, msg *packet.Message) error {
+	publishFuture, err := c.PublishMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return publishFuture.WaitContext(ctx)
+}
+
 // PublishMessage will send a PublishPacket containing the passed message. It will
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
@@ -278,6 +416,7 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 
 	// create future
 	publishFuture := future.New()
+	publishFuture.Data.Store(publishedKey, time.Now())
 
 	// store future
 	c.futureStore.Put(publish.ID, publishFuture)
@@ -288,6 +427,9 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 		if err != nil {
 			return nil, c.cleanup(err, true, false)
 		}
+
+		// track packet
+		c.inflight.sent(publish.ID, time.Now())
 	}
 
 	// send packet
@@ -298,6 +440,7 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 
 	// complete and remove qos 0 future
 	if msg.QOS == 0 {
+		c.measure(publishFuture, 0)
 		publishFuture.Complete()
 		c.futureStore.Delete(publish.ID)
 	}
@@ -314,6 +457,32 @@ func (c *Client) Subscribe(topic string, qos uint8) (SubscribeFuture, error) {
 	})
 }
 
+// SubscribeContext will send a SubscribePacket containing one topic to
+// subscribe and block until a SubackPacket has been received or the context is
+// done.
+func (c *Client) SubscribeContext(ctx context.Context, topic string, qos uint8) (SubscribeFuture, error) {
+	return c.SubscribeMultipleContext(ctx, []packet.Subscription{
+		{Topic: topic, QOS: qos},
+	})
+}
+
+// SubscribeMultipleContext will send a SubscribePacket containing multiple
+// topics to subscribe and block until a SubackPacket has been received or the
+// context is done.
+func (c *Client) SubscribeMultipleContext(ctx context.Context, subscriptions []packet.Subscription) (SubscribeFuture, error) {
+	subscribeFuture, err := c.SubscribeMultiple(subscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = subscribeFuture.WaitContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return subscribeFuture, nil
+}
+
 // SubscribeMultiple will send a SubscribePacket containing multiple topics to
 // subscribe. It will return a SubscribeFuture that gets completed once a
 // SubackPacket has been received.
@@ -356,6 +525,25 @@ func (c *Client) Unsubscribe(topic string) (GenericFuture, error) {
 	return c.UnsubscribeMultiple([]string{topic})
 }
 
+// UnsubscribeContext will send a UnsubscribePacket containing one topic to
+// unsubscribe and block until a UnsubackPacket has been received or the context
+// is done.
+func (c *Client) UnsubscribeContext(ctx context.Context, topic string) error {
+	return c.UnsubscribeMultipleContext(ctx, []string{topic})
+}
+
+// UnsubscribeMultipleContext will send a UnsubscribePacket containing multiple
+// topics to unsubscribe and block until a UnsubackPacket has been received or
+// the context is done.
+func (c *Client) UnsubscribeMultipleContext(ctx context.Context, topics []string) error {
+	unsubscribeFuture, err := c.UnsubscribeMultiple(topics)
+	if err != nil {
+		return err
+	}
+
+	return unsubscribeFuture.WaitContext(ctx)
+}
+
 // UnsubscribeMultiple will send a UnsubscribePacket containing multiple
 // topics to unsubscribe. It will return a UnsubscribeFuture that gets completed
 // once a UnsubackPacket has been received.
@@ -388,12 +576,83 @@ func (c *Client) UnsubscribeMultiple(topics []string) (GenericFuture, error) {
 	return unsubscribeFuture, nil
 }
 
+// Ack will acknowledge a QOS 1 or 2 message received in manual
+// acknowledgement mode. Messages may be acknowledged from any goroutine and in
+// any order, but the acknowledgements are only sent once all previously
+// received messages have been acknowledged as well.
+func (c *Client) Ack(msg *packet.Message) error {
+	// check if connected
+	if atomic.LoadUint32(&c.state) != clientConnected {
+		return ErrClientNotConnected
+	}
+
+	c.ackMutex.Lock()
+
+	// mark message as acknowledged
+	found := false
+	for _, ack := range c.acks {
+		if &ack.publish.Message == msg && !ack.acked {
+			ack.acked = true
+			found = true
+			break
+		}
+	}
+
+	// check if found
+	if !found {
+		c.ackMutex.Unlock()
+		return ErrUnknownMessage
+	}
+
+	// send acknowledgements in order
+	err := c.flushAcks()
+	if err != nil {
+		c.ackMutex.Unlock()
+		c.die(err, false, false)
+		return err
+	}
+
+	c.ackMutex.Unlock()
+
+	// notify processor
+	select {
+	case c.ackSignal <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Stats will return a snapshot of the keep alive and connection quality
+// statistics of the client.
+func (c *Client) Stats() Stats {
+	return c.stats.snapshot()
+}
+
 // Disconnect will send a DisconnectPacket and close the connection.
 //
 // If a timeout is specified, the client will wait the specified amount of time
 // for all queued futures to complete or cancel. If no timeout is specified it
 // will not wait at all.
 func (c *Client) Disconnect(timeout ...time.Duration) error {
+	// prepare context
+	ctx := context.Background()
+	if len(timeout) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout[0])
+		defer cancel()
+	}
+
+	return c.disconnect(ctx, len(timeout) > 0)
+}
+
+// DisconnectContext will send a DisconnectPacket and close the connection after
+// all queued futures have completed or canceled or the context is done.
+func (c *Client) DisconnectContext(ctx context.Context) error {
+	return c.disconnect(ctx, true)
+}
+
+func (c *Client) disconnect(ctx context.Context, await bool) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -403,8 +662,8 @@ func (c *Client) Disconnect(timeout ...time.Duration) error {
 	}
 
 	// finish current packets
-	if len(timeout) > 0 {
-		c.futureStore.Await(timeout[0])
+	if await {
+		c.futureStore.AwaitContext(ctx)
 	}
 
 	// set state
@@ -441,6 +700,11 @@ func (c *Client) processor() error {
 		c.tomb.Go(c.pinger)
 	}
 
+	// start retransmissions if enabled
+	if c.RetransmitTimeout > 0 {
+		c.tomb.Go(c.retransmitter)
+	}
+
 	for {
 		// get next packet from connection
 		pkt, err := c.conn.Receive()
@@ -454,6 +718,9 @@ func (c *Client) processor() error {
 			return c.die(err, false, false)
 		}
 
+		// count received bytes
+		c.stats.received(pkt.Len())
+
 		// log received message
 		if c.Logger != nil {
 			c.Logger(fmt.Sprintf("Received: %s", pkt.String()))
@@ -482,12 +749,13 @@ func (c *Client) processor() error {
 			err = c.processUnsuback(typedPkt)
 		case *packet.PingrespPacket:
 			c.tracker.pong()
+			c.stats.pong(c.tracker.roundTrip())
 		case *packet.PublishPacket:
 			err = c.processPublish(typedPkt)
 		case *packet.PubackPacket:
-			err = c.processPubackAndPubcomp(typedPkt.ID)
+			err = c.processPubackAndPubcomp(typedPkt.ID, 1)
 		case *packet.PubcompPacket:
-			err = c.processPubackAndPubcomp(typedPkt.ID)
+			err = c.processPubackAndPubcomp(typedPkt.ID, 2)
 		case *packet.PubrecPacket:
 			err = c.processPubrec(typedPkt.ID)
 		case *packet.PubrelPacket:
@@ -541,6 +809,16 @@ func (c *Client) processConnack(connack *packet.ConnackPacket) error {
 		if ok {
 			// set the dup flag on a publish packet
 			publish.Dup = true
+
+			// count retransmission
+			if publishFuture := c.futureStore.Get(publish.ID); publishFuture != nil {
+				countRetransmission(publishFuture)
+			}
+		}
+
+		// track packet
+		if id, ok := packet.GetID(pkt); ok {
+			c.inflight.sent(id, time.Now())
 		}
 
 		// resend packet
@@ -612,8 +890,31 @@ func (c *Client) processUnsuback(unsuback *packet.UnsubackPacket) error {
 
 // handle an incoming PublishPacket
 func (c *Client) processPublish(publish *packet.PublishPacket) error {
+	// check for duplicates
+	dup := false
+	if publish.Message.QOS == 1 && c.Deduplicator != nil {
+		dup = c.Deduplicator.duplicate(publish, time.Now())
+		if dup && c.Logger != nil {
+			c.Logger(fmt.Sprintf("Suppressed Duplicate: %s", publish.String()))
+		}
+	}
+
+	// handle manual acknowledgements
+	if c.ManualAck && publish.Message.QOS > 0 {
+		// check for redelivered qos 2 messages
+		if publish.Message.QOS == 2 {
+			var err error
+			dup, err = c.received(publish.ID)
+			if err != nil {
+				return c.die(err, true, false)
+			}
+		}
+
+		return c.processManualPublish(publish, dup)
+	}
+
 	// call callback for unacknowledged and directly acknowledged messages
-	if publish.Message.QOS <= 1 {
+	if publish.Message.QOS <= 1 && !dup {
 		if c.Callback != nil {
 			err := c.Callback(&publish.Message, nil)
 			if err != nil {
@@ -657,20 +958,88 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 	return nil
 }
 
+// handle an incoming PublishPacket that is acknowledged using Ack
+func (c *Client) processManualPublish(publish *packet.PublishPacket, dup bool) error {
+	// acknowledge duplicates in order without calling the callback
+	if dup {
+		c.ackMutex.Lock()
+		c.acks = append(c.acks, &pendingAck{publish: publish, acked: true})
+		err := c.flushAcks()
+		c.ackMutex.Unlock()
+		if err != nil {
+			return c.die(err, false, false)
+		}
+
+		return nil
+	}
+
+	// add pending ack before calling the callback as it may ack immediately
+	c.ackMutex.Lock()
+	c.acks = append(c.acks, &pendingAck{publish: publish})
+	c.ackMutex.Unlock()
+
+	// call callback
+	if c.Callback != nil {
+		err := c.Callback(&publish.Message, nil)
+		if err != nil {
+			return c.die(err, true, true)
+		}
+	}
+
+	// wait while too many messages are unacknowledged
+	for c.MaxUnacked > 0 && c.unacked() >= c.MaxUnacked {
+		select {
+		case <-c.ackSignal:
+		case <-c.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+
+	return nil
+}
+
+// returns whether a qos 2 message with the specified id has already been
+// received and not yet been released
+func (c *Client) received(id packet.ID) (bool, error) {
+	// check pending acknowledgements
+	c.ackMutex.Lock()
+	for _, ack := range c.acks {
+		if ack.publish.ID == id && ack.publish.Message.QOS == 2 {
+			c.ackMutex.Unlock()
+			return true, nil
+		}
+	}
+	c.ackMutex.Unlock()
+
+	// check session
+	pkt, err := c.Session.LookupPacket(session.Incoming, id)
+	if err != nil {
+		return false, err
+	}
+
+	return pkt != nil, nil
+}
+
 // handle an incoming PubackPacket or PubcompPacket
-func (c *Client) processPubackAndPubcomp(id packet.ID) error {
+func (c *Client) processPubackAndPubcomp(id packet.ID, qos uint8) error {
 	// remove packet from store
 	err := c.Session.DeletePacket(session.Outgoing, id)
 	if err != nil {
 		return err
 	}
 
+	// stop tracking packet
+	c.inflight.remove(id)
+
 	// get future
 	publishFuture := c.futureStore.Get(id)
 	if publishFuture == nil {
 		return nil // ignore a wrongly sent PubackPacket or PubcompPacket
 	}
 
+	// measure latency
+	c.measure(publishFuture, qos)
+
 	// complete future
 	publishFuture.Complete()
 
@@ -692,6 +1061,9 @@ func (c *Client) processPubrec(id packet.ID) error {
 		return c.die(err, true, false)
 	}
 
+	// track packet
+	c.inflight.sent(id, time.Now())
+
 	// send packet
 	err = c.send(pubrel, true)
 	if err != nil {
@@ -715,8 +1087,8 @@ func (c *Client) processPubrel(id packet.ID) error {
 		return nil // ignore a wrongly sent PubrelPacket
 	}
 
-	// call callback
-	if c.Callback != nil {
+	// call callback if not already called in manual acknowledgement mode
+	if c.Callback != nil && !c.ManualAck {
 		err = c.Callback(&publish.Message, nil)
 		if err != nil {
 			return c.die(err, true, true)
@@ -765,6 +1137,7 @@ func (c *Client) pinger() error {
 
 			// save ping attempt
 			c.tracker.ping()
+			c.stats.ping()
 		} else {
 			// log keep alive delay
 			if c.Logger != nil {
@@ -781,8 +1154,128 @@ func (c *Client) pinger() error {
 	}
 }
 
+/* retransmitter goroutine */
+
+// resends unacknowledged packets after the retransmit timeout
+func (c *Client) retransmitter() error {
+	for {
+		select {
+		case <-c.tomb.Dying():
+			return tomb.ErrDying
+		case <-time.After(c.RetransmitTimeout / 4):
+		}
+
+		// get due packets
+		now := time.Now()
+		for _, id := range c.inflight.due(c.RetransmitTimeout, now) {
+			// check retransmissions
+			if c.MaxRetransmits > 0 && c.inflight.attempts(id) >= c.MaxRetransmits {
+				return c.die(ErrClientRetransmitsExceeded, true, false)
+			}
+
+			// get packet from store
+			pkt, err := c.Session.LookupPacket(session.Outgoing, id)
+			if err != nil {
+				return c.die(err, true, false)
+			}
+
+			// stop tracking packets that have been acknowledged meanwhile
+			if pkt == nil {
+				c.inflight.remove(id)
+				continue
+			}
+
+			// check for publish packets
+			publish, ok := pkt.(*packet.PublishPacket)
+			if ok {
+				// set the dup flag on a publish packet
+				publish.Dup = true
+
+				// count retransmission
+				if publishFuture := c.futureStore.Get(id); publishFuture != nil {
+					countRetransmission(publishFuture)
+				}
+			}
+
+			// log retransmission
+			if c.Logger != nil {
+				c.Logger(fmt.Sprintf("Retransmit: %s", pkt.String()))
+			}
+
+			// resend packet
+			err = c.send(pkt, false)
+			if err != nil {
+				return c.die(err, false, false)
+			}
+
+			// mark retransmission
+			c.inflight.resent(id, now)
+		}
+	}
+}
+
 /* helpers */
 
+// a pendingAck is a message awaiting a manual acknowledgement
+type pendingAck struct {
+	publish *packet.PublishPacket
+	acked   bool
+}
+
+// sends the PubackPacket or PubrecPacket for a manually acknowledged message
+func (c *Client) acknowledge(publish *packet.PublishPacket) error {
+	// handle qos 1 flow
+	if publish.Message.QOS == 1 {
+		puback := packet.NewPubackPacket()
+		puback.ID = publish.ID
+
+		return c.send(puback, false)
+	}
+
+	// store packet
+	err := c.Session.SavePacket(session.Incoming, publish)
+	if err != nil {
+		return err
+	}
+
+	// prepare pubrec packet
+	pubrec := packet.NewPubrecPacket()
+	pubrec.ID = publish.ID
+
+	return c.send(pubrec, false)
+}
+
+// sends the acknowledgements of all leading acknowledged messages, the ack
+// mutex must be held
+func (c *Client) flushAcks() error {
+	for len(c.acks) > 0 && c.acks[0].acked {
+		err := c.acknowledge(c.acks[0].publish)
+		if err != nil {
+			return err
+		}
+
+		c.acks[0] = nil
+		c.acks = c.acks[1:]
+	}
+
+	return nil
+}
+
+// records the acknowledgement latency of a publish future
+func (c *Client) measure(publishFuture *future.Future, qos uint8) {
+	if v, ok := publishFuture.Data.Load(publishedKey); ok {
+		c.stats.publish(qos, time.Since(v.(time.Time)))
+	}
+}
+
+// returns the number of unacknowledged messages
+func (c *Client) unacked() int {
+	c.ackMutex.Lock()
+	defer c.ackMutex.Unlock()
+
+	return len(c.acks)
+}
+
 // sends packet and updates lastSend
 func (c *Client) send(pkt packet.GenericPacket, buffered bool) error {
 	// reset keep alive tracker
@@ -799,6 +1292,9 @@ func (c *Client) send(pkt packet.GenericPacket, buffered bool) error {
 		return err
 	}
 
+	// count sent bytes
+	c.stats.sent(pkt.Len())
+
 	// log sent packet
 	if c.Logger != nil {
 		c.Logger(fmt.Sprintf("Sent: %s", pkt.String()))
@@ -836,6 +1332,11 @@ func (c *Client) cleanup(err error, doClose bool, possiblyClosed bool) error {
 	// cancel all futures
 	c.futureStore.Clear()
 
+	// drop pending acknowledgements
+	c.ackMutex.Lock()
+	c.acks = nil
+	c.ackMutex.Unlock()
+
 	return err
 }
 
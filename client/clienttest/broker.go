@@ -0,0 +1,417 @@
+// Package clienttest implements an in-memory fake broker and a fake service for
+// testing applications that use the client package.
+package clienttest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+	"github.com/256dpi/gomqtt/transport"
+)
+
+// ErrNotConnected is returned by Inject if no client is connected.
+var ErrNotConnected = errors.New("not connected")
+
+// TestingT is the subset of testing.T used to report failed expectations.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// A Broker is an in-memory fake broker that accepts a single connection at a
+// time. Clients connect to it using the config returned by Config. The broker
+// acknowledges all packets, records the published messages and subscriptions
+// and allows to inject messages and simulate failures.
+type Broker struct {
+	// The return code sent in response to connect packets.
+	ReturnCode packet.ConnackCode
+
+	// The delay before acknowledgements of publish, subscribe and unsubscribe
+	// packets are sent.
+	AckDelay time.Duration
+
+	conn          *brokerConn
+	connects      int
+	published     []*packet.Message
+	subscriptions *topic.Tree
+	rejected      map[string]bool
+	signal        chan struct{}
+	mutex         sync.Mutex
+}
+
+// a brokerConn is a single connection to the broker
+type brokerConn struct {
+	conn   transport.Conn
+	queue  chan packet.GenericPacket
+	done   chan struct{}
+	nextID packet.ID
+}
+
+// NewBroker will create and return a new Broker.
+func NewBroker() *Broker {
+	return &Broker{
+		ReturnCode:    packet.ConnectionAccepted,
+		subscriptions: topic.NewTree(),
+		rejected:      make(map[string]bool),
+		signal:        make(chan struct{}),
+	}
+}
+
+// Dialer will return a dialer that connects to the broker using the "fake"
+// scheme.
+func (b *Broker) Dialer() *transport.Dialer {
+	d := transport.NewDialer()
+	d.Register("fake", b.dial)
+
+	return d
+}
+
+// Config will return a new config that connects to the broker.
+func (b *Broker) Config() *client.Config {
+	config := client.NewConfig("fake://broker")
+	config.Dialer = b.Dialer()
+	return config
+}
+
+// Reject will make the broker reject subscriptions to the specified filter.
+func (b *Broker) Reject(filter string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.rejected[filter] = true
+}
+
+// Inject will send the message to the connected client if it has a matching
+// subscription. The QOS of the message is downgraded to the QOS of the
+// subscription. It will return whether the message has been sent.
+func (b *Broker) Inject(msg *packet.Message) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// check connection
+	if b.conn == nil {
+		return false, ErrNotConnected
+	}
+
+	// get subscription
+	value := b.subscriptions.MatchFirst(msg.Topic)
+	if value == nil {
+		return false, nil
+	}
+
+	// prepare packet
+	publish := packet.NewPublishPacket()
+	publish.Message = *msg
+	if sub := value.(*packet.Subscription); sub.QOS < msg.QOS {
+		publish.Message.QOS = sub.QOS
+	}
+
+	// set packet id
+	if publish.Message.QOS > 0 {
+		b.conn.nextID++
+		publish.ID = b.conn.nextID
+	}
+
+	// queue packet
+	b.conn.send(publish)
+
+	return true, nil
+}
+
+// Disconnect will close the current connection to simulate a network failure.
+func (b *Broker) Disconnect() {
+	b.mutex.Lock()
+	conn := b.conn
+	b.mutex.Unlock()
+
+	if conn != nil {
+		conn.conn.Close()
+		<-conn.done
+	}
+}
+
+// Connected will return whether a client is connected.
+func (b *Broker) Connected() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.conn != nil
+}
+
+// Connects will return the number of accepted connections.
+func (b *Broker) Connects() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.connects
+}
+
+// Published will return all messages published by clients.
+func (b *Broker) Published() []*packet.Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]*packet.Message(nil), b.published...)
+}
+
+// Subscriptions will return the active subscriptions of the connected client.
+func (b *Broker) Subscriptions() []packet.Subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var subs []packet.Subscription
+	for _, value := range b.subscriptions.All() {
+		subs = append(subs, *value.(*packet.Subscription))
+	}
+
+	return subs
+}
+
+// WaitConnected will wait until a client is connected or the timeout is
+// reached. It will return whether a client is connected.
+func (b *Broker) WaitConnected(timeout time.Duration) bool {
+	return b.wait(timeout, func() bool {
+		return b.conn != nil
+	})
+}
+
+// ExpectPublish will wait until a message with the specified topic and payload
+// has been published or the timeout is reached. A nil payload matches any
+// payload. It will report an error using t and return nil if no message has
+// been published.
+func (b *Broker) ExpectPublish(t TestingT, topic string, payload []byte, timeout time.Duration) *packet.Message {
+	var msg *packet.Message
+	if !b.wait(timeout, func() bool {
+		for _, m := range b.published {
+			if m.Topic == topic && (payload == nil || bytes.Equal(m.Payload, payload)) {
+				msg = m
+				return true
+			}
+		}
+
+		return false
+	}) {
+		t.Errorf("expected publish on %q with payload %q", topic, payload)
+		return nil
+	}
+
+	return msg
+}
+
+// ExpectSubscription will wait until the specified filter has been subscribed
+// or the timeout is reached. It will report an error using t and return false
+// if the filter has not been subscribed.
+func (b *Broker) ExpectSubscription(t TestingT, filter string, timeout time.Duration) bool {
+	if !b.wait(timeout, func() bool {
+		return len(b.subscriptions.Get(filter)) > 0
+	}) {
+		t.Errorf("expected subscription to %q", filter)
+		return false
+	}
+
+	return true
+}
+
+// waits until the condition is met, the mutex is held while calling it
+func (b *Broker) wait(timeout time.Duration, condition func() bool) bool {
+	deadline := time.After(timeout)
+
+	for {
+		// check condition
+		b.mutex.Lock()
+		ok := condition()
+		signal := b.signal
+		b.mutex.Unlock()
+		if ok {
+			return true
+		}
+
+		// wait for change
+		select {
+		case <-signal:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// notifies waiting goroutines about a change, the mutex must be held
+func (b *Broker) notify() {
+	close(b.signal)
+	b.signal = make(chan struct{})
+}
+
+func (b *Broker) dial(ctx context.Context, url *url.URL) (transport.Conn, error) {
+	// create pipe
+	clientSide, brokerSide := net.Pipe()
+
+	// prepare connection
+	conn := &brokerConn{
+		conn:  transport.NewNetConn(brokerSide),
+		queue: make(chan packet.GenericPacket, 100),
+		done:  make(chan struct{}),
+	}
+
+	// run connection
+	go conn.writer()
+	go b.process(conn)
+
+	return transport.NewNetConn(clientSide), nil
+}
+
+func (b *Broker) process(conn *brokerConn) {
+	// ensure connection is cleaned up
+	defer func() {
+		conn.conn.Close()
+
+		b.mutex.Lock()
+		if b.conn == conn {
+			b.conn = nil
+			b.subscriptions.Reset()
+		}
+		b.notify()
+		b.mutex.Unlock()
+
+		close(conn.done)
+	}()
+
+	for {
+		// receive next packet
+		pkt, err := conn.conn.Receive()
+		if err != nil {
+			return
+		}
+
+		// handle packet
+		if !b.handle(conn, pkt) {
+			return
+		}
+	}
+}
+
+func (b *Broker) handle(conn *brokerConn, pkt packet.GenericPacket) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch p := pkt.(type) {
+	case *packet.ConnectPacket:
+		// send connack
+		connack := packet.NewConnackPacket()
+		connack.ReturnCode = b.ReturnCode
+		conn.send(connack)
+
+		// check return code
+		if b.ReturnCode != packet.ConnectionAccepted {
+			return true
+		}
+
+		// close previous connection
+		if b.conn != nil {
+			b.conn.conn.Close()
+		}
+
+		// set connection
+		b.conn = conn
+		b.connects++
+		b.subscriptions.Reset()
+	case *packet.SubscribePacket:
+		// add subscriptions
+		suback := packet.NewSubackPacket()
+		suback.ID = p.ID
+		for i := range p.Subscriptions {
+			sub := &p.Subscriptions[i]
+			if b.rejected[sub.Topic] {
+				suback.ReturnCodes = append(suback.ReturnCodes, packet.QOSFailure)
+				continue
+			}
+
+			b.subscriptions.Set(sub.Topic, sub)
+			suback.ReturnCodes = append(suback.ReturnCodes, sub.QOS)
+		}
+
+		b.ack(conn, suback)
+	case *packet.UnsubscribePacket:
+		// remove subscriptions
+		for _, t := range p.Topics {
+			b.subscriptions.Empty(t)
+		}
+
+		unsuback := packet.NewUnsubackPacket()
+		unsuback.ID = p.ID
+		b.ack(conn, unsuback)
+	case *packet.PublishPacket:
+		// record message
+		msg := p.Message
+		b.published = append(b.published, &msg)
+
+		// acknowledge message
+		if p.Message.QOS == 1 {
+			puback := packet.NewPubackPacket()
+			puback.ID = p.ID
+			b.ack(conn, puback)
+		} else if p.Message.QOS == 2 {
+			pubrec := packet.NewPubrecPacket()
+			pubrec.ID = p.ID
+			b.ack(conn, pubrec)
+		}
+	case *packet.PubrelPacket:
+		pubcomp := packet.NewPubcompPacket()
+		pubcomp.ID = p.ID
+		conn.send(pubcomp)
+	case *packet.PubrecPacket:
+		pubrel := packet.NewPubrelPacket()
+		pubrel.ID = p.ID
+		conn.send(pubrel)
+	case *packet.PingreqPacket:
+		conn.send(packet.NewPingrespPacket())
+	case *packet.DisconnectPacket:
+		return false
+	}
+
+	// notify waiters
+	b.notify()
+
+	return true
+}
+
+// sends the acknowledgement after the configured delay
+func (b *Broker) ack(conn *brokerConn, pkt packet.GenericPacket) {
+	if b.AckDelay > 0 {
+		time.AfterFunc(b.AckDelay, func() {
+			conn.send(pkt)
+		})
+
+		return
+	}
+
+	conn.send(pkt)
+}
+
+// queues the packet unless the connection is closed
+func (c *brokerConn) send(pkt packet.GenericPacket) {
+	select {
+	case c.queue <- pkt:
+	case <-c.done:
+	}
+}
+
+// writes queued packets to the connection
+func (c *brokerConn) writer() {
+	for {
+		select {
+		case pkt := <-c.queue:
+			if c.conn.Send(pkt) != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package clienttest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	b.Reject("bar")
+
+	messages := make(chan *packet.Message, 10)
+
+	s := client.NewService()
+	s.MessageCallback = func(msg *packet.Message) error {
+		messages <- msg
+		return nil
+	}
+
+	config := b.Config()
+	config.ValidateSubs = false
+
+	s.Start(config)
+	assert.True(t, b.WaitConnected(time.Second))
+
+	sf := s.Subscribe("foo/+", 1)
+	assert.NoError(t, sf.Wait(time.Second))
+	assert.Equal(t, []uint8{1}, sf.ReturnCodes())
+	assert.True(t, b.ExpectSubscription(t, "foo/+", time.Second))
+
+	sf = s.Subscribe("bar", 1)
+	assert.NoError(t, sf.Wait(time.Second))
+	assert.Equal(t, []uint8{packet.QOSFailure}, sf.ReturnCodes())
+	assert.Equal(t, []packet.Subscription{{Topic: "foo/+", QOS: 1}}, b.Subscriptions())
+
+	assert.NoError(t, s.Publish("baz", []byte("1"), 1, false).Wait(time.Second))
+	assert.NoError(t, s.Publish("baz", []byte("2"), 2, false).Wait(time.Second))
+	msg := b.ExpectPublish(t, "baz", []byte("2"), time.Second)
+	assert.Equal(t, uint8(2), msg.QOS)
+	assert.Len(t, b.Published(), 2)
+
+	ok, err := b.Inject(&packet.Message{Topic: "foo/bar", Payload: []byte("3"), QOS: 2})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	msg = <-messages
+	assert.Equal(t, "foo/bar", msg.Topic)
+	assert.Equal(t, uint8(1), msg.QOS)
+
+	ok, err = b.Inject(&packet.Message{Topic: "qux"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	b.Disconnect()
+	assert.True(t, b.WaitConnected(5*time.Second))
+	assert.Equal(t, 2, b.Connects())
+	assert.True(t, b.ExpectSubscription(t, "foo/+", time.Second))
+
+	s.Stop(true)
+
+	ok, err = b.Inject(&packet.Message{Topic: "foo/bar"})
+	assert.Equal(t, ErrNotConnected, err)
+	assert.False(t, ok)
+
+	ft := &fakeT{}
+	assert.Nil(t, b.ExpectPublish(ft, "qux", nil, 10*time.Millisecond))
+	assert.False(t, b.ExpectSubscription(ft, "qux", 10*time.Millisecond))
+	assert.Len(t, ft.errors, 2)
+}
+
+func TestBrokerAckDelay(t *testing.T) {
+	b := NewBroker()
+	b.AckDelay = 50 * time.Millisecond
+
+	c := client.New()
+
+	cf, err := c.Connect(b.Config())
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	pf, err := c.Publish("foo", nil, 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, future.ErrTimeout, pf.Wait(10*time.Millisecond))
+	assert.NoError(t, pf.Wait(time.Second))
+
+	assert.NoError(t, c.Disconnect())
+}
+
+func TestBrokerReturnCode(t *testing.T) {
+	b := NewBroker()
+	b.ReturnCode = packet.ErrNotAuthorized
+
+	c := client.New()
+
+	cf, err := c.Connect(b.Config())
+	assert.NoError(t, err)
+	assert.Equal(t, future.ErrCanceled, cf.Wait(time.Second))
+	assert.Equal(t, packet.ErrNotAuthorized, cf.ReturnCode())
+	assert.False(t, b.Connected())
+}
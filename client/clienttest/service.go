@@ -0,0 +1,313 @@
+package clienttest
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// Interface contains the methods shared by client.Service and Service. It can
+// be used by applications to replace the service in tests.
+//
+// Note: The helpers of the client package that are built on top of a service
+// (Router, Caller, Responder, Sender, Receiver and the PublishCodec and
+// PublishJSON methods) require a concrete client.Service and cannot be used
+// with Service. Applications using them should be tested against a
+// client.Service connected to a Broker instead.
+type Interface interface {
+	Start(config *client.Config)
+	Stop(clearFutures bool)
+	Publish(topic string, payload []byte, qos uint8, retain bool) client.GenericFuture
+	PublishMessage(msg *packet.Message) client.GenericFuture
+	Subscribe(topic string, qos uint8) client.SubscribeFuture
+	SubscribeMultiple(subscriptions []packet.Subscription) client.SubscribeFuture
+	Unsubscribe(topic string) client.GenericFuture
+	UnsubscribeMultiple(topics []string) client.GenericFuture
+	QueueDepth() int
+	Stats() client.Stats
+	BrokerURL() string
+}
+
+var _ Interface = &client.Service{}
+var _ Interface = &Service{}
+
+// A Service is a fake service that does not connect to a broker. All methods
+// are executed synchronously: published messages are recorded and their futures
+// are completed immediately, and injected messages are passed directly to the
+// MessageCallback if a matching subscription exists.
+type Service struct {
+	// The callback that is used to notify that the service is online.
+	OnlineCallback client.OnlineCallback
+
+	// The callback to be called by the service upon receiving a message.
+	MessageCallback client.MessageCallback
+
+	// The callback to be called by the service upon encountering an error.
+	ErrorCallback client.ErrorCallback
+
+	// The callback that is used to notify that the service is offline.
+	OfflineCallback client.OfflineCallback
+
+	config        *client.Config
+	started       bool
+	published     []*packet.Message
+	tracked       []packet.Subscription
+	subscriptions *topic.Tree
+	rejected      map[string]bool
+	mutex         sync.Mutex
+}
+
+// NewService will create and return a new Service.
+func NewService() *Service {
+	return &Service{
+		subscriptions: topic.NewTree(),
+		rejected:      make(map[string]bool),
+	}
+}
+
+// Start will mark the service as started and call the OnlineCallback.
+func (s *Service) Start(config *client.Config) {
+	s.mutex.Lock()
+	s.config = config
+	s.started = true
+	s.mutex.Unlock()
+
+	if s.OnlineCallback != nil {
+		s.OnlineCallback(false)
+	}
+}
+
+// Stop will mark the service as stopped and call the OfflineCallback.
+func (s *Service) Stop(clearFutures bool) {
+	s.mutex.Lock()
+	started := s.started
+	s.started = false
+	s.mutex.Unlock()
+
+	if started && s.OfflineCallback != nil {
+		s.OfflineCallback()
+	}
+}
+
+// Reconnect will call the OfflineCallback and the OnlineCallback to simulate a
+// reconnect. Like client.Service, all requested subscriptions are replayed if
+// the session is not resumed. Filters that are rejected by then are not
+// restored.
+func (s *Service) Reconnect(resumed bool) {
+	s.mutex.Lock()
+	if !resumed {
+		s.subscriptions.Reset()
+		s.subscribe(s.tracked)
+	}
+	s.mutex.Unlock()
+
+	if s.OfflineCallback != nil {
+		s.OfflineCallback()
+	}
+
+	if s.OnlineCallback != nil {
+		s.OnlineCallback(resumed)
+	}
+}
+
+// Publish will record a message with the specified parameters.
+func (s *Service) Publish(topic string, payload []byte, qos uint8, retain bool) client.GenericFuture {
+	return s.PublishMessage(&packet.Message{
+		Topic:   topic,
+		Payload: payload,
+		QOS:     qos,
+		Retain:  retain,
+	})
+}
+
+// PublishMessage will record the message and return a completed future.
+func (s *Service) PublishMessage(msg *packet.Message) client.GenericFuture {
+	s.mutex.Lock()
+	s.published = append(s.published, msg)
+	s.mutex.Unlock()
+
+	f := future.New()
+	f.Complete()
+
+	return f
+}
+
+// Subscribe will record a subscription to the specified topic.
+func (s *Service) Subscribe(topic string, qos uint8) client.SubscribeFuture {
+	return s.SubscribeMultiple([]packet.Subscription{
+		{Topic: topic, QOS: qos},
+	})
+}
+
+// SubscribeMultiple will record the subscriptions and return a completed
+// future. Rejected subscriptions get the packet.QOSFailure return code.
+func (s *Service) SubscribeMultiple(subscriptions []packet.Subscription) client.SubscribeFuture {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// track subscriptions
+	for _, sub := range subscriptions {
+		s.untrack(sub.Topic)
+		s.tracked = append(s.tracked, sub)
+	}
+
+	// add subscriptions
+	codes := s.subscribe(subscriptions)
+
+	f := future.New()
+	f.Complete()
+
+	return &subscribeFuture{Future: f, codes: codes}
+}
+
+// Unsubscribe will remove the subscription to the specified topic.
+func (s *Service) Unsubscribe(topic string) client.GenericFuture {
+	return s.UnsubscribeMultiple([]string{topic})
+}
+
+// UnsubscribeMultiple will remove the subscriptions to the specified topics
+// and return a completed future.
+func (s *Service) UnsubscribeMultiple(topics []string) client.GenericFuture {
+	s.mutex.Lock()
+	for _, t := range topics {
+		s.untrack(t)
+		s.subscriptions.Empty(t)
+	}
+	s.mutex.Unlock()
+
+	f := future.New()
+	f.Complete()
+
+	return f
+}
+
+// QueueDepth will always return zero as nothing is queued.
+func (s *Service) QueueDepth() int {
+	return 0
+}
+
+// Stats will return empty statistics.
+func (s *Service) Stats() client.Stats {
+	return client.Stats{
+		ReconnectReasons: map[string]int64{},
+	}
+}
+
+// BrokerURL will return the broker URL of the config passed to Start or an
+// empty string if the service is stopped.
+func (s *Service) BrokerURL() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.started || s.config == nil {
+		return ""
+	}
+
+	return s.config.BrokerURL
+}
+
+// Reject will make the service reject subscriptions to the specified filter.
+func (s *Service) Reject(filter string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rejected[filter] = true
+}
+
+// Inject will pass the message to the MessageCallback if a matching
+// subscription exists. It will return whether the message has been passed and
+// the error returned by the callback, which is also emitted using the
+// ErrorCallback.
+func (s *Service) Inject(msg *packet.Message) (bool, error) {
+	// check subscription
+	s.mutex.Lock()
+	value := s.subscriptions.MatchFirst(msg.Topic)
+	s.mutex.Unlock()
+	if value == nil || s.MessageCallback == nil {
+		return false, nil
+	}
+
+	// call callback
+	err := s.MessageCallback(msg)
+	if err != nil && s.ErrorCallback != nil {
+		s.ErrorCallback(err)
+	}
+
+	return true, err
+}
+
+// Published will return all published messages.
+func (s *Service) Published() []*packet.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*packet.Message(nil), s.published...)
+}
+
+// Subscriptions will return the active subscriptions.
+func (s *Service) Subscriptions() []packet.Subscription {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var subs []packet.Subscription
+	for _, value := range s.subscriptions.All() {
+		subs = append(subs, *value.(*packet.Subscription))
+	}
+
+	return subs
+}
+
+// ExpectPublish will check if a message with the specified topic and payload
+// has been published. A nil payload matches any payload. It will report an
+// error using t and return nil if no message has been published.
+func (s *Service) ExpectPublish(t TestingT, topic string, payload []byte) *packet.Message {
+	for _, msg := range s.Published() {
+		if msg.Topic == topic && (payload == nil || bytes.Equal(msg.Payload, payload)) {
+			return msg
+		}
+	}
+
+	t.Errorf("expected publish on %q with payload %q", topic, payload)
+
+	return nil
+}
+
+// adds the subscriptions that are not rejected and returns the return codes
+func (s *Service) subscribe(subscriptions []packet.Subscription) []uint8 {
+	var codes []uint8
+	for _, sub := range subscriptions {
+		if s.rejected[sub.Topic] {
+			codes = append(codes, packet.QOSFailure)
+			continue
+		}
+
+		sub := sub
+		s.subscriptions.Set(sub.Topic, &sub)
+		codes = append(codes, sub.QOS)
+	}
+
+	return codes
+}
+
+// removes the tracked subscription with the specified topic
+func (s *Service) untrack(topic string) {
+	for i, sub := range s.tracked {
+		if sub.Topic == topic {
+			s.tracked = append(s.tracked[:i], s.tracked[i+1:]...)
+			return
+		}
+	}
+}
+
+type subscribeFuture struct {
+	*future.Future
+	codes []uint8
+}
+
+func (f *subscribeFuture) ReturnCodes() []uint8 {
+	return f.codes
+}
@@ -0,0 +1,99 @@
+package clienttest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService(t *testing.T) {
+	var events []string
+	var messages []*packet.Message
+	var errs []error
+
+	s := NewService()
+	s.Reject("bar")
+
+	s.OnlineCallback = func(resumed bool) {
+		events = append(events, "online")
+		assert.Equal(t, "tcp://localhost:1883", s.BrokerURL())
+	}
+
+	s.OfflineCallback = func() {
+		events = append(events, "offline")
+	}
+
+	s.MessageCallback = func(msg *packet.Message) error {
+		messages = append(messages, msg)
+		if msg.Topic == "foo/err" {
+			return errors.New("foo")
+		}
+
+		return nil
+	}
+
+	s.ErrorCallback = func(err error) {
+		errs = append(errs, err)
+	}
+
+	s.Start(client.NewConfig("tcp://localhost:1883"))
+
+	sf := s.SubscribeMultiple([]packet.Subscription{{Topic: "foo/#", QOS: 1}, {Topic: "bar"}})
+	assert.NoError(t, sf.Wait(time.Second))
+	assert.Equal(t, []uint8{1, packet.QOSFailure}, sf.ReturnCodes())
+	assert.Equal(t, []packet.Subscription{{Topic: "foo/#", QOS: 1}}, s.Subscriptions())
+
+	assert.NoError(t, s.Publish("baz", []byte("1"), 1, false).Wait(time.Second))
+	assert.NotNil(t, s.ExpectPublish(t, "baz", []byte("1")))
+
+	ok, err := s.Inject(&packet.Message{Topic: "foo/bar"})
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = s.Inject(&packet.Message{Topic: "foo/err"})
+	assert.True(t, ok)
+	assert.Equal(t, errors.New("foo"), err)
+
+	ok, err = s.Inject(&packet.Message{Topic: "bar"})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	assert.Len(t, messages, 2)
+	assert.Equal(t, []error{errors.New("foo")}, errs)
+
+	s.Reconnect(true)
+	assert.Equal(t, []packet.Subscription{{Topic: "foo/#", QOS: 1}}, s.Subscriptions())
+
+	s.Reject("foo/#")
+	s.Reconnect(false)
+	assert.Empty(t, s.Subscriptions())
+
+	assert.NoError(t, s.Unsubscribe("foo/#").Wait(time.Second))
+
+	s.Stop(true)
+
+	assert.Equal(t, []string{"online", "offline", "online", "offline", "online", "offline"}, events)
+
+	ft := &fakeT{}
+	assert.Nil(t, s.ExpectPublish(ft, "qux", nil))
+	assert.Len(t, ft.errors, 1)
+}
+
+func TestServiceReplay(t *testing.T) {
+	s := NewService()
+	s.Start(client.NewConfig("tcp://localhost:1883"))
+
+	assert.NoError(t, s.Subscribe("foo", 0).Wait(time.Second))
+	assert.NoError(t, s.Subscribe("bar", 1).Wait(time.Second))
+	assert.NoError(t, s.Subscribe("foo", 2).Wait(time.Second))
+	assert.NoError(t, s.Unsubscribe("bar").Wait(time.Second))
+
+	s.Reconnect(false)
+	assert.Equal(t, []packet.Subscription{{Topic: "foo", QOS: 2}}, s.Subscriptions())
+
+	s.Stop(true)
+}
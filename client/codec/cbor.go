@@ -0,0 +1,418 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"time"
+)
+
+const (
+	cborUint   byte = 0
+	cborNegInt byte = 1
+	cborBytes  byte = 2
+	cborText   byte = 3
+	cborArray  byte = 4
+	cborMap    byte = 5
+	cborTag    byte = 6
+	cborSimple byte = 7
+)
+
+const (
+	cborFalse     byte = 0xf4
+	cborTrue      byte = 0xf5
+	cborNull      byte = 0xf6
+	cborUndefined byte = 0xf7
+	cborFloat16   byte = 0xf9
+	cborFloat32   byte = 0xfa
+	cborFloat64   byte = 0xfb
+	cborBreak     byte = 0xff
+)
+
+const (
+	cborTagTime  = 0
+	cborTagEpoch = 1
+)
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (cborCodec) Encode(v interface{}) ([]byte, error) {
+	w := &cborWriter{}
+	err := encodeValue(w, reflect.ValueOf(v), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.buf, nil
+}
+
+func (cborCodec) Decode(data []byte, v interface{}) error {
+	// decode value
+	r := &cborReader{data: data}
+	g, err := r.value(0)
+	if err != nil {
+		return err
+	} else if r.pos != len(data) {
+		return ErrInvalidData
+	}
+
+	return decodeInto(g, v)
+}
+
+type cborWriter struct {
+	buf []byte
+}
+
+func (w *cborWriter) head(major byte, n uint64) {
+	major <<= 5
+
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, major|25, 0, 0)
+		binary.BigEndian.PutUint16(w.buf[len(w.buf)-2:], uint16(n))
+	case n <= math.MaxUint32:
+		w.buf = append(w.buf, major|26, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(w.buf[len(w.buf)-4:], uint32(n))
+	default:
+		w.buf = append(w.buf, major|27, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], n)
+	}
+}
+
+func (w *cborWriter) writeNil() {
+	w.buf = append(w.buf, cborNull)
+}
+
+func (w *cborWriter) writeBool(b bool) {
+	if b {
+		w.buf = append(w.buf, cborTrue)
+	} else {
+		w.buf = append(w.buf, cborFalse)
+	}
+}
+
+func (w *cborWriter) writeInt(i int64) {
+	if i < 0 {
+		w.head(cborNegInt, uint64(-1-i))
+		return
+	}
+
+	w.head(cborUint, uint64(i))
+}
+
+func (w *cborWriter) writeUint(u uint64) {
+	w.head(cborUint, u)
+}
+
+func (w *cborWriter) writeFloat32(f float32) {
+	w.buf = append(w.buf, cborFloat32, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(w.buf[len(w.buf)-4:], math.Float32bits(f))
+}
+
+func (w *cborWriter) writeFloat64(f float64) {
+	w.buf = append(w.buf, cborFloat64, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], math.Float64bits(f))
+}
+
+func (w *cborWriter) writeBytes(b []byte) {
+	w.head(cborBytes, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *cborWriter) writeString(s string) {
+	w.head(cborText, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *cborWriter) writeTime(t time.Time) {
+	w.head(cborTag, cborTagTime)
+	w.writeString(t.Format(time.RFC3339Nano))
+}
+
+func (w *cborWriter) writeArray(n int) {
+	w.head(cborArray, uint64(n))
+}
+
+func (w *cborWriter) writeMap(n int) {
+	w.head(cborMap, uint64(n))
+}
+
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+// reads the major type, additional information and argument of the next item
+// and whether its length is indefinite
+func (r *cborReader) head() (byte, byte, uint64, bool, error) {
+	// read initial byte
+	if r.pos >= len(r.data) {
+		return 0, 0, 0, false, ErrInvalidData
+	}
+	b := r.data[r.pos]
+	r.pos++
+
+	major := b >> 5
+	info := b & 0x1f
+
+	// read argument
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return major, info, 0, true, nil
+	default:
+		return 0, 0, 0, false, ErrInvalidData
+	}
+
+	// check length
+	if len(r.data)-r.pos < size {
+		return 0, 0, 0, false, ErrInvalidData
+	}
+
+	// decode argument
+	var n uint64
+	for _, c := range r.data[r.pos : r.pos+size] {
+		n = n<<8 | uint64(c)
+	}
+	r.pos += size
+
+	return major, info, n, false, nil
+}
+
+func (r *cborReader) value(depth int) (interface{}, error) {
+	// check depth
+	if depth > maxDepth {
+		return nil, ErrInvalidData
+	}
+
+	// read head
+	major, info, n, indefinite, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+
+	// check indefinite lengths
+	if indefinite && (major < cborBytes || major >= cborTag) {
+		return nil, ErrInvalidData
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, ErrUnsupportedType
+		}
+
+		return -1 - int64(n), nil
+	case cborBytes, cborText:
+		// read chunks of indefinite strings
+		var buf []byte
+		if indefinite {
+			buf = []byte{}
+			for !r.end() {
+				chunk, err := r.value(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+
+				// chunks must be definite strings of the same type
+				if major == cborBytes {
+					b, ok := chunk.([]byte)
+					if !ok {
+						return nil, ErrInvalidData
+					}
+
+					buf = append(buf, b...)
+				} else {
+					s, ok := chunk.(string)
+					if !ok {
+						return nil, ErrInvalidData
+					}
+
+					buf = append(buf, s...)
+				}
+			}
+		} else {
+			buf, err = r.read(n)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if major == cborText {
+			return string(buf), nil
+		}
+
+		return buf, nil
+	case cborArray:
+		// check length, every item takes at least one byte
+		if !indefinite && n > uint64(len(r.data)-r.pos) {
+			return nil, ErrInvalidData
+		}
+
+		list := make([]interface{}, 0, int(n))
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && r.end() {
+				break
+			}
+
+			item, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, item)
+		}
+
+		return list, nil
+	case cborMap:
+		// check length, every entry takes at least two bytes
+		if !indefinite && n > uint64(len(r.data)-r.pos)/2 {
+			return nil, ErrInvalidData
+		}
+
+		m := make(genericMap, 0, int(n))
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && r.end() {
+				break
+			}
+
+			key, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			m = append(m, genericPair{key: key, value: value})
+		}
+
+		return m, nil
+	case cborTag:
+		// read content
+		content, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		// convert times
+		switch n {
+		case cborTagTime:
+			s, ok := content.(string)
+			if !ok {
+				return nil, ErrInvalidData
+			}
+
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, ErrInvalidData
+			}
+
+			return t, nil
+		case cborTagEpoch:
+			if _, ok := content.(string); ok {
+				return nil, ErrInvalidData
+			}
+
+			t, ok := toTime(content)
+			if !ok {
+				return nil, ErrInvalidData
+			}
+
+			return t, nil
+		}
+
+		// ignore other tags
+		return content, nil
+	default:
+		return r.simple(info, n)
+	}
+}
+
+func (r *cborReader) simple(info byte, n uint64) (interface{}, error) {
+	switch info {
+	case cborFalse & 0x1f:
+		return false, nil
+	case cborTrue & 0x1f:
+		return true, nil
+	case cborNull & 0x1f, cborUndefined & 0x1f:
+		return nil, nil
+	case cborFloat16 & 0x1f:
+		return halfToFloat(uint16(n)), nil
+	case cborFloat32 & 0x1f:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case cborFloat64 & 0x1f:
+		return math.Float64frombits(n), nil
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// returns whether the next byte is a break and consumes it
+func (r *cborReader) end() bool {
+	if r.pos < len(r.data) && r.data[r.pos] == cborBreak {
+		r.pos++
+		return true
+	}
+
+	return false
+}
+
+func (r *cborReader) read(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrInvalidData
+	}
+
+	buf := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+
+	return buf, nil
+}
+
+// converts a IEEE 754 half precision float
+func halfToFloat(h uint16) float64 {
+	exp := int(h >> 10 & 0x1f)
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+
+	return f
+}
@@ -0,0 +1,90 @@
+// Package codec implements payload encodings used to publish and receive
+// typed messages.
+//
+// The CBOR and MessagePack codecs encode values using reflection. Struct fields
+// are named using the usual json struct tags, byte slices are encoded as byte
+// strings and time values use the standard date/time tag of CBOR and the
+// timestamp extension of MessagePack. Decoded values are stored in empty
+// interfaces as bool, int64, uint64, float64, string, []byte, time.Time,
+// []interface{} and map[string]interface{} values.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrUnsupportedType is returned if a value cannot be handled by a codec.
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// ErrInvalidData is returned if the data cannot be decoded.
+var ErrInvalidData = errors.New("invalid data")
+
+// A Codec encodes values into payloads and decodes payloads into values.
+type Codec interface {
+	// ContentType returns the MIME type of the encoding.
+	ContentType() string
+
+	// Encode returns the encoding of the value.
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode parses the data and stores the result in the value pointed to
+	// by v.
+	Decode(data []byte, v interface{}) error
+}
+
+// JSON encodes values using the encoding/json package.
+var JSON Codec = jsonCodec{}
+
+// CBOR encodes values in the Concise Binary Object Representation (RFC 7049).
+var CBOR Codec = cborCodec{}
+
+// MsgPack encodes values in the MessagePack format.
+var MsgPack Codec = msgpackCodec{}
+
+// Raw passes byte slices and strings through unchanged.
+var Raw Codec = rawCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Encode(v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+func (rawCodec) Decode(data []byte, v interface{}) error {
+	switch value := v.(type) {
+	case *[]byte:
+		*value = data
+	case *string:
+		*value = string(data)
+	default:
+		return ErrUnsupportedType
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testValue struct {
+	Name   string            `json:"name"`
+	Count  int64             `json:"count"`
+	Ratio  float64           `json:"ratio"`
+	Active bool              `json:"active"`
+	Tags   []string          `json:"tags"`
+	Meta   map[string]uint64 `json:"meta"`
+	Data   []byte            `json:"data"`
+	Time   time.Time         `json:"time"`
+	Next   *testValue        `json:"next"`
+	Skip   string            `json:"-"`
+	Empty  string            `json:"empty,omitempty"`
+}
+
+func unhex(s string) []byte {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return buf
+}
+
+func TestCodecs(t *testing.T) {
+	value := testValue{
+		Name:   strings.Repeat("x", 300),
+		Count:  -123456789,
+		Ratio:  0.5,
+		Active: true,
+		Tags:   []string{"a", "b"},
+		Meta:   map[string]uint64{"max": 1<<64 - 1, "min": 0},
+		Data:   []byte{1, 2, 3},
+		Time:   time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Next:   &testValue{Count: -1},
+	}
+
+	for _, c := range []Codec{JSON, CBOR, MsgPack} {
+		buf, err := c.Encode(value)
+		assert.NoError(t, err, c.ContentType())
+
+		var out testValue
+		assert.NoError(t, c.Decode(buf, &out), c.ContentType())
+		assert.Equal(t, value, out, c.ContentType())
+
+		assert.Error(t, c.Decode(buf[:len(buf)-1], &out), c.ContentType())
+	}
+}
+
+func TestCBOREncode(t *testing.T) {
+	for _, item := range []struct {
+		value interface{}
+		data  string
+	}{
+		// RFC 7049 Appendix A
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{1000000000000, "1b000000e8d4a51000"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-100, "3863"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{float32(100000.0), "fa47c35000"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{}, "40"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]int{}, "80"},
+		{[]interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{map[string]int{}, "a0"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+
+		// structs
+		{struct {
+			A int `json:"a"`
+			B []byte
+		}{1, []byte{2}}, "a261610161424102"},
+	} {
+		buf, err := CBOR.Encode(item.value)
+		assert.NoError(t, err)
+		assert.Equal(t, item.data, hex.EncodeToString(buf), item.value)
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	for _, item := range []struct {
+		data  string
+		value interface{}
+	}{
+		// RFC 7049 Appendix A
+		{"00", int64(0)},
+		{"1bffffffffffffffff", uint64(18446744073709551615)},
+		{"3863", int64(-100)},
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-08},
+		{"fa47c35000", 100000.0},
+		{"f7", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"62c3bc", "ü"},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a26161016162820203", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9fff", []interface{}{}},
+		{"9f018202039f0405ffff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"c11a514b67b0", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", "http://www.example.com"},
+	} {
+		var value interface{}
+		assert.NoError(t, CBOR.Decode(unhex(item.data), &value), item.data)
+		assert.Equal(t, item.value, value, item.data)
+	}
+
+	var f float32
+	assert.NoError(t, CBOR.Decode(unhex("f9fc00"), &f))
+	assert.True(t, math.IsInf(float64(f), -1))
+
+	var i int8
+	assert.Equal(t, ErrUnsupportedType, CBOR.Decode(unhex("190100"), &i))
+}
+
+func TestMsgPackEncode(t *testing.T) {
+	for _, item := range []struct {
+		value interface{}
+		data  string
+	}{
+		{nil, "c0"},
+		{false, "c2"},
+		{true, "c3"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{256, "cd0100"},
+		{65536, "ce00010000"},
+		{uint64(1 << 32), "cf0000000100000000"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{-129, "d1ff7f"},
+		{-32769, "d2ffff7fff"},
+		{int64(-2147483649), "d3ffffffff7fffffff"},
+		{1.5, "cb3ff8000000000000"},
+		{float32(1.5), "ca3fc00000"},
+		{"", "a0"},
+		{"a", "a161"},
+		{strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{[]byte{1, 2, 3}, "c403010203"},
+		{[]int{}, "90"},
+		{[]int{1, 2, 3}, "93010203"},
+		{make([]bool, 16), "dc0010" + strings.Repeat("c2", 16)},
+		{map[string]int{"a": 1}, "81a16101"},
+		{map[string]interface{}{"a": 1, "b": []int{-1, 500}}, "82a16101a16292ffcd01f4"},
+		{time.Unix(1, 0), "d6ff00000001"},
+		{time.Unix(1, 1), "d7ff0000000400000001"},
+		{time.Unix(-1, 0), "c70cff00000000ffffffffffffffff"},
+	} {
+		buf, err := MsgPack.Encode(item.value)
+		assert.NoError(t, err)
+		assert.Equal(t, item.data, hex.EncodeToString(buf), item.value)
+	}
+}
+
+func TestMsgPackDecode(t *testing.T) {
+	for _, item := range []struct {
+		data  string
+		value interface{}
+	}{
+		{"c0", nil},
+		{"7f", int64(127)},
+		{"cfffffffffffffffff", uint64(18446744073709551615)},
+		{"e0", int64(-32)},
+		{"d1ff38", int64(-200)},
+		{"d3ffffffff7fffffff", int64(-2147483649)},
+		{"ca3fc00000", 1.5},
+		{"d90161", "a"},
+		{"c403010203", []byte{1, 2, 3}},
+		{"dd00000002c3c2", []interface{}{true, false}},
+		{"de0001a16101", map[string]interface{}{"a": int64(1)}},
+		{"d6ff00000001", time.Unix(1, 0).UTC()},
+		{"d7ff0000000400000001", time.Unix(1, 1).UTC()},
+		{"c70cff00000000ffffffffffffffff", time.Unix(-1, 0).UTC()},
+	} {
+		var value interface{}
+		assert.NoError(t, MsgPack.Decode(unhex(item.data), &value), item.data)
+		assert.Equal(t, item.value, value, item.data)
+	}
+
+	var i int
+	assert.Equal(t, ErrUnsupportedType, MsgPack.Decode(unhex("d40100"), &i))
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, 2000)
+
+	for _, data := range []string{
+		"",
+		"9b7fffffffffffffff",
+		"5b7fffffffffffffff",
+		"bb7fffffffffffffff",
+		"9f",
+		"ff",
+		"1c",
+		"0000",
+		hex.EncodeToString(deep),
+	} {
+		var value interface{}
+		assert.Equal(t, ErrInvalidData, CBOR.Decode(unhex(data), &value), data)
+	}
+
+	for _, data := range []string{
+		"",
+		"c1",
+		"ddffffffff",
+		"dfffffffff",
+		"c6ffffffff",
+		"dbffffffff",
+		"c7ffffffff",
+		"d6ff0000",
+		"0000",
+		strings.Repeat("91", 2000),
+	} {
+		var value interface{}
+		assert.Equal(t, ErrInvalidData, MsgPack.Decode(unhex(data), &value), data)
+	}
+}
+
+func TestDecodeInvalidKeys(t *testing.T) {
+	for _, item := range []struct {
+		codec Codec
+		data  string
+	}{
+		{CBOR, "a1f601"},
+		{CBOR, "a2616101f601"},
+		{CBOR, "a1800102"},
+		{CBOR, "a1a00102"},
+		{CBOR, "81a1f601"},
+		{MsgPack, "81c001"},
+		{MsgPack, "82a16101c001"},
+		{MsgPack, "819001"},
+		{MsgPack, "818001"},
+		{MsgPack, "9181c001"},
+	} {
+		var value interface{}
+		assert.Equal(t, ErrInvalidData, item.codec.Decode(unhex(item.data), &value), item.data)
+	}
+
+	for _, item := range []struct {
+		codec Codec
+		data  string
+	}{
+		{CBOR, "a1f601"},
+		{CBOR, "a1800102"},
+		{MsgPack, "81c001"},
+		{MsgPack, "819001"},
+	} {
+		var m map[interface{}]interface{}
+		assert.Equal(t, ErrInvalidData, item.codec.Decode(unhex(item.data), &m), item.data)
+	}
+
+	var s map[struct{ A interface{} }]int
+	assert.Equal(t, ErrInvalidData, CBOR.Decode(unhex("a1a161618001"), &s))
+	assert.Equal(t, ErrInvalidData, MsgPack.Decode(unhex("8181a1619001"), &s))
+}
+
+func TestRaw(t *testing.T) {
+	buf, err := Raw.Encode("test")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test"), buf)
+
+	var b []byte
+	assert.NoError(t, Raw.Decode(buf, &b))
+	assert.Equal(t, []byte("test"), b)
+
+	_, err = Raw.Encode(1)
+	assert.Equal(t, ErrUnsupportedType, err)
+}
@@ -0,0 +1,399 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"time"
+)
+
+// the extension type of timestamps (-1)
+const msgpackTimestamp byte = 0xff
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	w := &msgpackWriter{}
+	err := encodeValue(w, reflect.ValueOf(v), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.buf, nil
+}
+
+func (msgpackCodec) Decode(data []byte, v interface{}) error {
+	// decode value
+	r := &msgpackReader{data: data}
+	g, err := r.value(0)
+	if err != nil {
+		return err
+	} else if r.pos != len(data) {
+		return ErrInvalidData
+	}
+
+	return decodeInto(g, v)
+}
+
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) put(b byte, n uint64, size int) {
+	w.buf = append(w.buf, b)
+	for i := size - 1; i >= 0; i-- {
+		w.buf = append(w.buf, byte(n>>(uint(i)*8)))
+	}
+}
+
+func (w *msgpackWriter) writeNil() {
+	w.buf = append(w.buf, 0xc0)
+}
+
+func (w *msgpackWriter) writeBool(b bool) {
+	if b {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		w.writeUint(uint64(i))
+	case i >= -32:
+		w.buf = append(w.buf, byte(i))
+	case i >= math.MinInt8:
+		w.put(0xd0, uint64(i), 1)
+	case i >= math.MinInt16:
+		w.put(0xd1, uint64(i), 2)
+	case i >= math.MinInt32:
+		w.put(0xd2, uint64(i), 4)
+	default:
+		w.put(0xd3, uint64(i), 8)
+	}
+}
+
+func (w *msgpackWriter) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		w.buf = append(w.buf, byte(u))
+	case u <= math.MaxUint8:
+		w.put(0xcc, u, 1)
+	case u <= math.MaxUint16:
+		w.put(0xcd, u, 2)
+	case u <= math.MaxUint32:
+		w.put(0xce, u, 4)
+	default:
+		w.put(0xcf, u, 8)
+	}
+}
+
+func (w *msgpackWriter) writeFloat32(f float32) {
+	w.put(0xca, uint64(math.Float32bits(f)), 4)
+}
+
+func (w *msgpackWriter) writeFloat64(f float64) {
+	w.put(0xcb, math.Float64bits(f), 8)
+}
+
+func (w *msgpackWriter) writeBytes(b []byte) {
+	switch n := uint64(len(b)); {
+	case n <= math.MaxUint8:
+		w.put(0xc4, n, 1)
+	case n <= math.MaxUint16:
+		w.put(0xc5, n, 2)
+	default:
+		w.put(0xc6, n, 4)
+	}
+
+	w.buf = append(w.buf, b...)
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	switch n := uint64(len(s)); {
+	case n < 32:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.put(0xd9, n, 1)
+	case n <= math.MaxUint16:
+		w.put(0xda, n, 2)
+	default:
+		w.put(0xdb, n, 4)
+	}
+
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) writeTime(t time.Time) {
+	sec := t.Unix()
+	nsec := uint64(t.Nanosecond())
+
+	switch {
+	case uint64(sec)>>34 != 0:
+		// timestamp 96
+		w.buf = append(w.buf, 0xc7, 12, msgpackTimestamp)
+		w.buf = append(w.buf, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(w.buf[len(w.buf)-12:], uint32(nsec))
+		binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], uint64(sec))
+	case nsec == 0 && sec <= math.MaxUint32:
+		// timestamp 32
+		w.buf = append(w.buf, 0xd6)
+		w.put(msgpackTimestamp, uint64(sec), 4)
+	default:
+		// timestamp 64
+		w.buf = append(w.buf, 0xd7)
+		w.put(msgpackTimestamp, nsec<<34|uint64(sec), 8)
+	}
+}
+
+func (w *msgpackWriter) writeArray(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		w.put(0xdc, uint64(n), 2)
+	default:
+		w.put(0xdd, uint64(n), 4)
+	}
+}
+
+func (w *msgpackWriter) writeMap(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		w.put(0xde, uint64(n), 2)
+	default:
+		w.put(0xdf, uint64(n), 4)
+	}
+}
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+// reads a big endian unsigned integer of the specified size
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	if len(r.data)-r.pos < size {
+		return 0, ErrInvalidData
+	}
+
+	var n uint64
+	for _, c := range r.data[r.pos : r.pos+size] {
+		n = n<<8 | uint64(c)
+	}
+	r.pos += size
+
+	return n, nil
+}
+
+func (r *msgpackReader) read(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrInvalidData
+	}
+
+	buf := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+
+	return buf, nil
+}
+
+func (r *msgpackReader) value(depth int) (interface{}, error) {
+	// check depth
+	if depth > maxDepth {
+		return nil, ErrInvalidData
+	}
+
+	// read type
+	if r.pos >= len(r.data) {
+		return nil, ErrInvalidData
+	}
+	b := r.data[r.pos]
+	r.pos++
+
+	switch {
+	case b <= 0x7f:
+		return uint64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return r.mapValue(uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return r.array(uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return r.str(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+
+		buf, err := r.read(n)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf, nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+
+		return r.ext(n)
+	case 0xca:
+		n, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 0xcb:
+		n, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+
+		return math.Float64frombits(n), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (b - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+
+		// sign extend
+		shift := uint(64 - size*8)
+		return int64(n<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+
+		return r.str(n)
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+
+		return r.array(n, depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+
+		return r.mapValue(n, depth)
+	default:
+		return nil, ErrInvalidData
+	}
+}
+
+func (r *msgpackReader) str(n uint64) (interface{}, error) {
+	buf, err := r.read(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(buf), nil
+}
+
+func (r *msgpackReader) array(n uint64, depth int) (interface{}, error) {
+	// check length, every item takes at least one byte
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrInvalidData
+	}
+
+	list := make([]interface{}, 0, int(n))
+	for i := uint64(0); i < n; i++ {
+		item, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, item)
+	}
+
+	return list, nil
+}
+
+func (r *msgpackReader) mapValue(n uint64, depth int) (interface{}, error) {
+	// check length, every entry takes at least two bytes
+	if n > uint64(len(r.data)-r.pos)/2 {
+		return nil, ErrInvalidData
+	}
+
+	m := make(genericMap, 0, int(n))
+	for i := uint64(0); i < n; i++ {
+		key, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		m = append(m, genericPair{key: key, value: value})
+	}
+
+	return m, nil
+}
+
+// reads an extension with the specified data length, only timestamps are
+// supported
+func (r *msgpackReader) ext(n uint64) (interface{}, error) {
+	// read type
+	typ, err := r.uint(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// read data
+	buf, err := r.read(n)
+	if err != nil {
+		return nil, err
+	}
+
+	// check type
+	if byte(typ) != msgpackTimestamp {
+		return nil, ErrUnsupportedType
+	}
+
+	switch len(buf) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(buf)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(buf)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(buf)
+		sec := binary.BigEndian.Uint64(buf[4:])
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	default:
+		return nil, ErrInvalidData
+	}
+}
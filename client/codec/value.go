@@ -0,0 +1,733 @@
+package codec
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the maximum nesting of arrays and maps
+const maxDepth = 1000
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// a writer appends values in a binary format
+type writer interface {
+	writeNil()
+	writeBool(bool)
+	writeInt(int64)
+	writeUint(uint64)
+	writeFloat32(float32)
+	writeFloat64(float64)
+	writeBytes([]byte)
+	writeString(string)
+	writeTime(time.Time)
+	writeArray(n int)
+	writeMap(n int)
+}
+
+// a decoded map that keeps the order of its entries
+type genericMap []genericPair
+
+type genericPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// writes the value using the writer
+func encodeValue(w writer, v reflect.Value, depth int) error {
+	// check depth
+	if depth > maxDepth {
+		return ErrUnsupportedType
+	}
+
+	// handle invalid values
+	if !v.IsValid() {
+		w.writeNil()
+		return nil
+	}
+
+	// handle time
+	if v.Type() == timeType {
+		w.writeTime(v.Interface().(time.Time))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+
+		return encodeValue(w, v.Elem(), depth+1)
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(v.Uint())
+	case reflect.Float32:
+		w.writeFloat32(float32(v.Float()))
+	case reflect.Float64:
+		w.writeFloat64(v.Float())
+	case reflect.String:
+		w.writeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+
+		// write byte slices natively
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			w.writeBytes(v.Bytes())
+			return nil
+		}
+
+		return encodeArray(w, v, depth)
+	case reflect.Array:
+		// write byte arrays natively
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			w.writeBytes(buf)
+			return nil
+		}
+
+		return encodeArray(w, v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+
+		return encodeMap(w, v, depth)
+	case reflect.Struct:
+		return encodeStruct(w, v, depth)
+	default:
+		return ErrUnsupportedType
+	}
+
+	return nil
+}
+
+func encodeArray(w writer, v reflect.Value, depth int) error {
+	w.writeArray(v.Len())
+
+	for i := 0; i < v.Len(); i++ {
+		err := encodeValue(w, v.Index(i), depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeMap(w writer, v reflect.Value, depth int) error {
+	// sort keys to get a deterministic encoding
+	keys := v.MapKeys()
+	switch v.Type().Key().Kind() {
+	case reflect.String:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+	default:
+		return ErrUnsupportedType
+	}
+
+	w.writeMap(len(keys))
+
+	for _, key := range keys {
+		err := encodeValue(w, key, depth+1)
+		if err != nil {
+			return err
+		}
+
+		err = encodeValue(w, v.MapIndex(key), depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeStruct(w writer, v reflect.Value, depth int) error {
+	// get fields that are not omitted
+	var fields []field
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmpty(fv)) {
+			continue
+		}
+
+		fields = append(fields, f)
+	}
+
+	w.writeMap(len(fields))
+
+	for _, f := range fields {
+		fv, _ := fieldByIndex(v, f.index)
+
+		w.writeString(f.name)
+
+		err := encodeValue(w, fv, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stores the decoded value in the value pointed to by v
+func decodeInto(g interface{}, v interface{}) error {
+	// check target
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrUnsupportedType
+	}
+
+	return assign(g, rv.Elem())
+}
+
+// assigns the decoded value to v
+func assign(g interface{}, v reflect.Value) error {
+	// handle pointers
+	if v.Kind() == reflect.Ptr {
+		if g == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return assign(g, v.Elem())
+	}
+
+	// handle interfaces
+	if v.Kind() == reflect.Interface {
+		if g == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		} else if v.NumMethod() != 0 {
+			return ErrUnsupportedType
+		}
+
+		n, err := natural(g)
+		if err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf(n))
+		return nil
+	}
+
+	// reset value on nil
+	if g == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	// handle time
+	if v.Type() == timeType {
+		t, ok := toTime(g)
+		if !ok {
+			return ErrUnsupportedType
+		}
+
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := g.(bool)
+		if !ok {
+			return ErrUnsupportedType
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInt(g)
+		if !ok || v.OverflowInt(i) {
+			return ErrUnsupportedType
+		}
+
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, ok := toUint(g)
+		if !ok || v.OverflowUint(u) {
+			return ErrUnsupportedType
+		}
+
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(g)
+		if !ok || v.OverflowFloat(f) {
+			return ErrUnsupportedType
+		}
+
+		v.SetFloat(f)
+	case reflect.String:
+		switch value := g.(type) {
+		case string:
+			v.SetString(value)
+		case []byte:
+			v.SetString(string(value))
+		default:
+			return ErrUnsupportedType
+		}
+	case reflect.Slice:
+		// handle byte slices
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if buf, ok := toBytes(g); ok {
+				v.SetBytes(append([]byte(nil), buf...))
+				return nil
+			}
+		}
+
+		list, ok := g.([]interface{})
+		if !ok {
+			return ErrUnsupportedType
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, item := range list {
+			err := assign(item, slice.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+
+		v.Set(slice)
+	case reflect.Array:
+		// handle byte arrays
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if buf, ok := toBytes(g); ok {
+				v.Set(reflect.Zero(v.Type()))
+				reflect.Copy(v, reflect.ValueOf(buf))
+				return nil
+			}
+		}
+
+		list, ok := g.([]interface{})
+		if !ok {
+			return ErrUnsupportedType
+		}
+
+		v.Set(reflect.Zero(v.Type()))
+		for i := 0; i < len(list) && i < v.Len(); i++ {
+			err := assign(list[i], v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := g.(genericMap)
+		if !ok {
+			return ErrUnsupportedType
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		for _, pair := range m {
+			// check key
+			if pair.key == nil {
+				return ErrInvalidData
+			}
+
+			key := reflect.New(v.Type().Key()).Elem()
+			err := assign(pair.key, key)
+			if err != nil {
+				return err
+			} else if !hashable(key) {
+				return ErrInvalidData
+			}
+
+			value := reflect.New(v.Type().Elem()).Elem()
+			err = assign(pair.value, value)
+			if err != nil {
+				return err
+			}
+
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		m, ok := g.(genericMap)
+		if !ok {
+			return ErrUnsupportedType
+		}
+
+		fields := cachedFields(v.Type())
+		for _, pair := range m {
+			// unknown keys are ignored
+			name, ok := pair.key.(string)
+			if !ok {
+				continue
+			}
+
+			f := lookupField(fields, name)
+			if f == nil {
+				continue
+			}
+
+			fv := fieldByIndexAlloc(v, f.index)
+			err := assign(pair.value, fv)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrUnsupportedType
+	}
+
+	return nil
+}
+
+// converts a decoded value into the value stored in an empty interface, maps
+// with null or non-comparable keys are rejected
+func natural(g interface{}) (interface{}, error) {
+	switch value := g.(type) {
+	case uint64:
+		if value <= math.MaxInt64 {
+			return int64(value), nil
+		}
+
+		return value, nil
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			n, err := natural(item)
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = n
+		}
+
+		return list, nil
+	case genericMap:
+		// use string keys if possible
+		stringKeys := true
+		for _, pair := range value {
+			if _, ok := pair.key.(string); !ok {
+				stringKeys = false
+				break
+			}
+		}
+
+		if stringKeys {
+			m := make(map[string]interface{}, len(value))
+			for _, pair := range value {
+				n, err := natural(pair.value)
+				if err != nil {
+					return nil, err
+				}
+
+				m[pair.key.(string)] = n
+			}
+
+			return m, nil
+		}
+
+		m := make(map[interface{}]interface{}, len(value))
+		for _, pair := range value {
+			key, err := natural(pair.key)
+			if err != nil {
+				return nil, err
+			} else if key == nil || !reflect.TypeOf(key).Comparable() {
+				return nil, ErrInvalidData
+			}
+
+			n, err := natural(pair.value)
+			if err != nil {
+				return nil, err
+			}
+
+			m[key] = n
+		}
+
+		return m, nil
+	default:
+		return g, nil
+	}
+}
+
+// returns whether the value can be used as a map key without panicking
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+
+		return true
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return v.Type().Comparable()
+	}
+}
+
+func toInt(g interface{}) (int64, bool) {
+	switch value := g.(type) {
+	case int64:
+		return value, true
+	case uint64:
+		return int64(value), value <= math.MaxInt64
+	case float64:
+		return int64(value), value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64
+	default:
+		return 0, false
+	}
+}
+
+func toUint(g interface{}) (uint64, bool) {
+	switch value := g.(type) {
+	case int64:
+		return uint64(value), value >= 0
+	case uint64:
+		return value, true
+	case float64:
+		return uint64(value), value == math.Trunc(value) && value >= 0 && value < math.MaxUint64
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(g interface{}) (float64, bool) {
+	switch value := g.(type) {
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	default:
+		return 0, false
+	}
+}
+
+func toBytes(g interface{}) ([]byte, bool) {
+	switch value := g.(type) {
+	case []byte:
+		return value, true
+	case string:
+		return []byte(value), true
+	default:
+		return nil, false
+	}
+}
+
+func toTime(g interface{}) (time.Time, bool) {
+	switch value := g.(type) {
+	case time.Time:
+		return value, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	case int64, uint64:
+		i, ok := toInt(value)
+		return time.Unix(i, 0).UTC(), ok
+	case float64:
+		sec, frac := math.Modf(value)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), !math.IsNaN(value) && !math.IsInf(value, 0)
+	default:
+		return time.Time{}, false
+	}
+}
+
+// the encoded field of a struct
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+var fieldCache sync.Map
+
+// returns the fields of the struct type using the json struct tags
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+
+	fields := structFields(t, nil, map[reflect.Type]bool{})
+	fieldCache.Store(t, fields)
+
+	return fields
+}
+
+func structFields(t reflect.Type, index []int, visited map[reflect.Type]bool) []field {
+	// prevent cycles through embedded structs
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+
+	var fields []field
+	var embedded []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		// parse tag
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		// get index
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		// inline untagged embedded structs
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			// embedded pointers to unexported structs cannot be allocated
+			if sf.PkgPath != "" && sf.Type.Kind() == reflect.Ptr {
+				continue
+			}
+
+			embedded = append(embedded, structFields(ft, fieldIndex, visited)...)
+			continue
+		}
+
+		// skip unexported fields
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		// add field
+		f := field{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+			tagged:    name != "",
+		}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+
+		fields = append(fields, f)
+	}
+
+	// add embedded fields that are not shadowed
+	for _, f := range embedded {
+		if lookupExactField(fields, f.name) == nil {
+			fields = append(fields, f)
+		}
+	}
+
+	delete(visited, t)
+
+	return fields
+}
+
+func lookupExactField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
+
+// finds a field by name and falls back to a case insensitive match
+func lookupField(fields []field, name string) *field {
+	if f := lookupExactField(fields, name); f != nil {
+		return f
+	}
+
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
+
+// returns the field and false if it is behind a nil embedded pointer
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+// returns the field and allocates nil embedded pointers
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
@@ -1,19 +1,50 @@
 package client
 
 import (
+	"crypto/tls"
+	"time"
+
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/256dpi/gomqtt/transport"
 )
 
+// Credentials are returned by a CredentialsProvider.
+type Credentials struct {
+	// The username and password replace the credentials embedded in the broker
+	// URL if the username is not empty.
+	Username string
+	Password string
+
+	// The TLS config replaces the TLS config of the dialer if set.
+	TLSConfig *tls.Config
+
+	// The time the credentials expire. A Service will reconnect proactively
+	// before the credentials expire if configured.
+	Expires time.Time
+}
+
+// A CredentialsProvider is called with the broker URL before each connection
+// attempt to fetch fresh credentials. A returned error aborts the attempt.
+type CredentialsProvider func(url string) (*Credentials, error)
+
 // A Config holds information about establishing a connection to a broker.
+//
+// A Service will use the BrokerURLs instead of the BrokerURL if set and select
+// the broker for each connection attempt using the BrokerStrategy.
+//
+// If a CredentialsProvider is set, it is called before each connection attempt
+// to fetch fresh credentials.
 type Config struct {
-	Dialer       *transport.Dialer
-	BrokerURL    string
-	ClientID     string
-	CleanSession bool
-	KeepAlive    string
-	WillMessage  *packet.Message
-	ValidateSubs bool
+	Dialer              *transport.Dialer
+	BrokerURL           string
+	BrokerURLs          []string
+	BrokerStrategy      BrokerStrategy
+	CredentialsProvider CredentialsProvider
+	ClientID            string
+	CleanSession        bool
+	KeepAlive           string
+	WillMessage         *packet.Message
+	ValidateSubs        bool
 }
 
 // NewConfig creates a new Config using the specified URL.
@@ -26,6 +57,15 @@ func NewConfig(url string) *Config {
 	}
 }
 
+// NewConfigWithBrokers creates a new Config using the specified URLs and
+// strategy.
+func NewConfigWithBrokers(strategy BrokerStrategy, urls ...string) *Config {
+	config := NewConfig("")
+	config.BrokerURLs = urls
+	config.BrokerStrategy = strategy
+	return config
+}
+
 // NewConfigWithClientID creates a new Config using the specified URL and client ID.
 func NewConfigWithClientID(url, id string) *Config {
 	config := NewConfig(url)
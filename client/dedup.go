@@ -0,0 +1,131 @@
+package client
+
+import (
+	"container/list"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A Deduplicator tracks the fingerprints of recently received QOS 1 messages to
+// suppress redeliveries before they are passed to the callback. Suppressed
+// messages are still acknowledged.
+//
+// By default the fingerprint consists of the packet id and a hash of the topic
+// and payload. As packet ids are reused by the broker, only redeliveries that
+// have the Dup flag set are suppressed. If an IDFunc is set, the returned id is
+// used as the fingerprint instead and all messages with a known id are
+// suppressed. Messages for which an empty id is returned are not tracked.
+//
+// A single Deduplicator may be shared between clients to detect duplicates
+// across reconnects.
+type Deduplicator struct {
+	// The duration for which a fingerprint is remembered.
+	Window time.Duration
+
+	// The maximum number of remembered fingerprints.
+	Size int
+
+	// The function that extracts an application defined message id.
+	IDFunc KeyFunc
+
+	entries map[string]*list.Element
+	order   *list.List
+	mutex   sync.Mutex
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// NewDeduplicator returns a new Deduplicator that remembers up to size
+// fingerprints for the specified window.
+func NewDeduplicator(window time.Duration, size int) *Deduplicator {
+	return &Deduplicator{
+		Window:  window,
+		Size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Len returns the number of remembered fingerprints.
+func (d *Deduplicator) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.order == nil {
+		return 0
+	}
+
+	return d.order.Len()
+}
+
+// duplicate will remember the fingerprint of the packet and return whether it
+// is a duplicate that should be suppressed
+func (d *Deduplicator) duplicate(publish *packet.PublishPacket, now time.Time) bool {
+	// get fingerprint
+	var key string
+	if d.IDFunc != nil {
+		key = d.IDFunc(&publish.Message)
+		if key == "" {
+			return false
+		}
+	} else {
+		hash := fnv.New64a()
+		hash.Write([]byte(publish.Message.Topic))
+		hash.Write([]byte{0})
+		hash.Write(publish.Message.Payload)
+		key = strconv.Itoa(int(publish.ID)) + ":" + strconv.FormatUint(hash.Sum64(), 16)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// initialize if created as a literal
+	if d.entries == nil {
+		d.entries = make(map[string]*list.Element)
+		d.order = list.New()
+	}
+
+	// remove expired fingerprints
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		if now.Sub(e.Value.(*dedupEntry).seen) < d.Window {
+			break
+		}
+
+		d.remove(e)
+	}
+
+	// check known fingerprint
+	if e, ok := d.entries[key]; ok {
+		if d.IDFunc != nil || publish.Dup {
+			return true
+		}
+
+		// refresh fingerprint
+		e.Value.(*dedupEntry).seen = now
+		d.order.MoveToBack(e)
+
+		return false
+	}
+
+	// remove oldest fingerprint if full
+	if d.Size > 0 && d.order.Len() >= d.Size {
+		d.remove(d.order.Front())
+	}
+
+	// add fingerprint
+	d.entries[key] = d.order.PushBack(&dedupEntry{key: key, seen: now})
+
+	return false
+}
+
+func (d *Deduplicator) remove(e *list.Element) {
+	delete(d.entries, e.Value.(*dedupEntry).key)
+	d.order.Remove(e)
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicator(t *testing.T) {
+	d := NewDeduplicator(time.Second, 2)
+	now := time.Now()
+
+	pkt := packet.NewPublishPacket()
+	pkt.Message = packet.Message{Topic: "test", Payload: []byte("1"), QOS: 1}
+	pkt.ID = 1
+
+	assert.False(t, d.duplicate(pkt, now))
+	assert.False(t, d.duplicate(pkt, now))
+
+	pkt.Dup = true
+	assert.True(t, d.duplicate(pkt, now))
+	assert.False(t, d.duplicate(pkt, now.Add(2*time.Second)))
+	assert.Equal(t, 1, d.Len())
+
+	pkt.ID = 2
+	assert.False(t, d.duplicate(pkt, now.Add(2*time.Second)))
+	pkt.ID = 3
+	assert.False(t, d.duplicate(pkt, now.Add(2*time.Second)))
+	assert.Equal(t, 2, d.Len())
+
+	pkt.ID = 1
+	assert.False(t, d.duplicate(pkt, now.Add(2*time.Second)))
+
+	d = NewDeduplicator(time.Second, 0)
+	d.IDFunc = func(msg *packet.Message) string {
+		return string(msg.Payload)
+	}
+
+	pkt.Dup = false
+	assert.False(t, d.duplicate(pkt, now))
+	pkt.ID = 5
+	assert.True(t, d.duplicate(pkt, now))
+
+	pkt.Message.Payload = nil
+	assert.False(t, d.duplicate(pkt, now))
+	assert.False(t, d.duplicate(pkt, now))
+}
+
+func TestDeduplicatorLiteral(t *testing.T) {
+	d := &Deduplicator{Window: time.Second}
+	assert.Equal(t, 0, d.Len())
+
+	pkt := packet.NewPublishPacket()
+	pkt.Message = packet.Message{Topic: "test", Payload: []byte("1"), QOS: 1}
+	pkt.ID = 1
+
+	assert.False(t, d.duplicate(pkt, time.Now()))
+
+	pkt.Dup = true
+	assert.True(t, d.duplicate(pkt, time.Now()))
+	assert.Equal(t, 1, d.Len())
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"time"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A DeliveryReport describes the outcome of a message published by a Service.
+type DeliveryReport struct {
+	// The published message.
+	Message *packet.Message
+
+	// The error is future.ErrCanceled if the message has not been delivered
+	// and nil otherwise.
+	Err error
+
+	// The time between the call to publish and the completion of the quality of
+	// service flow.
+	Latency time.Duration
+
+	// The number of times the message has been resent to the broker.
+	Retransmissions int
+}
+
+// registers a callback that emits a delivery report once the future is done
+func (s *Service) report(msg *packet.Message, f *future.Future) GenericFuture {
+	// check channel
+	if s.DeliveryReports == nil {
+		return f
+	}
+
+	// get start
+	start := time.Now()
+
+	f.OnComplete(func(err error) {
+		// prepare report
+		report := &DeliveryReport{
+			Message:         msg,
+			Err:             err,
+			Latency:         time.Since(start),
+			Retransmissions: retransmissions(f),
+		}
+
+		// send report if possible
+		select {
+		case s.DeliveryReports <- report:
+		default:
+		}
+	})
+
+	return f
+}
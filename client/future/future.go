@@ -2,6 +2,7 @@
 package future
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -20,6 +21,8 @@ type Future struct {
 
 	completeChannel chan struct{}
 	cancelChannel   chan struct{}
+	callbacks       []func(error)
+	mutex           sync.Mutex
 }
 
 // New will return a new Future.
@@ -38,13 +41,49 @@ func (f *Future) Bind(f2 *Future) {
 	select {
 	case <-f2.completeChannel:
 		f.Data = f2.Data
-		close(f.completeChannel)
+		f.Complete()
 	case <-f2.cancelChannel:
 		f.Data = f2.Data
-		close(f.cancelChannel)
+		f.Cancel()
 	}
 }
 
+// BindAsync will tie the current future to the specified future like Bind
+// without blocking the calling goroutine.
+func (f *Future) BindAsync(f2 *Future) {
+	f2.OnComplete(func(err error) {
+		f.Data = f2.Data
+
+		if err == nil {
+			f.Complete()
+		} else {
+			f.Cancel()
+		}
+	})
+}
+
+// OnComplete will register a callback that is called with nil once the future
+// is completed or with ErrCanceled once it is canceled. If the future is already
+// completed or canceled the callback is called immediately.
+//
+// Note: The callbacks are called from the goroutine that completes or cancels
+// the future and should therefore not block.
+func (f *Future) OnComplete(fn func(err error)) {
+	f.mutex.Lock()
+
+	// add callback if future is pending
+	if !f.done() {
+		f.callbacks = append(f.callbacks, fn)
+		f.mutex.Unlock()
+		return
+	}
+
+	f.mutex.Unlock()
+
+	// call callback immediately
+	fn(f.result())
+}
+
 // Wait will wait the given amount of time and return whether the future has been
 // completed, canceled or the request timed out.
 func (f *Future) Wait(timeout time.Duration) error {
@@ -58,26 +97,70 @@ func (f *Future) Wait(timeout time.Duration) error {
 	}
 }
 
-// Complete will complete the future.
-func (f *Future) Complete() {
-	// return if future has already been canceled
+// WaitContext will wait until the future is completed, canceled or the context
+// is done. It will return the error of the context in the latter case.
+func (f *Future) WaitContext(ctx context.Context) error {
 	select {
+	case <-f.completeChannel:
+		return nil
 	case <-f.cancelChannel:
-		return
-	default:
+		return ErrCanceled
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	close(f.completeChannel)
+// Complete will complete the future. The call has no effect if the future has
+// already been completed or canceled.
+func (f *Future) Complete() {
+	f.finish(f.completeChannel)
 }
 
-// Cancel will cancel the future.
+// Cancel will cancel the future. The call has no effect if the future has
+// already been completed or canceled.
 func (f *Future) Cancel() {
-	// return if future has already been completed
-	select {
-	case <-f.completeChannel:
+	f.finish(f.cancelChannel)
+}
+
+func (f *Future) finish(ch chan struct{}) {
+	f.mutex.Lock()
+
+	// return if future has already been completed or canceled
+	if f.done() {
+		f.mutex.Unlock()
 		return
+	}
+
+	// close channel and get callbacks
+	close(ch)
+	callbacks := f.callbacks
+	f.callbacks = nil
+
+	f.mutex.Unlock()
+
+	// call callbacks
+	err := f.result()
+	for _, fn := range callbacks {
+		fn(err)
+	}
+}
+
+func (f *Future) result() error {
+	select {
+	case <-f.cancelChannel:
+		return ErrCanceled
 	default:
+		return nil
 	}
+}
 
-	close(f.cancelChannel)
+func (f *Future) done() bool {
+	select {
+	case <-f.completeChannel:
+		return true
+	case <-f.cancelChannel:
+		return true
+	default:
+		return false
+	}
 }
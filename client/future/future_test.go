@@ -1,6 +1,7 @@
 package future
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -96,3 +97,81 @@ func TestFutureBindAfter(t *testing.T) {
 
 	<-done
 }
+
+func TestFutureBindAsync(t *testing.T) {
+	f := New()
+	f.Data.Store("foo", "bar")
+
+	ff := New()
+	ff.BindAsync(f)
+
+	f.Cancel()
+
+	assert.Equal(t, ErrCanceled, ff.Wait(10*time.Millisecond))
+	val, _ := ff.Data.Load("foo")
+	assert.Equal(t, "bar", val)
+}
+
+func TestFutureOnComplete(t *testing.T) {
+	var results []error
+
+	f := New()
+	f.OnComplete(func(err error) {
+		results = append(results, err)
+	})
+
+	f.Complete()
+	f.Cancel()
+
+	f.OnComplete(func(err error) {
+		results = append(results, err)
+	})
+
+	assert.Equal(t, []error{nil, nil}, results)
+
+	results = nil
+
+	f = New()
+	f.OnComplete(func(err error) {
+		results = append(results, err)
+	})
+
+	f.Cancel()
+	f.Complete()
+
+	f.OnComplete(func(err error) {
+		results = append(results, err)
+	})
+
+	assert.Equal(t, []error{ErrCanceled, ErrCanceled}, results)
+}
+
+func TestFutureCompleteTwice(t *testing.T) {
+	f := New()
+	f.Complete()
+	f.Complete()
+	f.Cancel()
+	assert.NoError(t, f.Wait(10*time.Millisecond))
+
+	f = New()
+	f.Cancel()
+	f.Cancel()
+	f.Complete()
+	assert.Equal(t, ErrCanceled, f.Wait(10*time.Millisecond))
+}
+
+func TestFutureWaitContext(t *testing.T) {
+	f := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, f.WaitContext(ctx))
+
+	f.Complete()
+	assert.NoError(t, f.WaitContext(context.Background()))
+
+	f = New()
+	f.Cancel()
+	assert.Equal(t, ErrCanceled, f.WaitContext(context.Background()))
+}
@@ -1,6 +1,7 @@
 package future
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -46,6 +47,18 @@ func (s *Store) Delete(id packet.ID) {
 	delete(s.store, id)
 }
 
+// Remove will remove the specified future from the store.
+func (s *Store) Remove(future *Future) {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, savedFuture := range s.store {
+		if savedFuture == future {
+			delete(s.store, id)
+		}
+	}
+}
+
 // All will return a slice with all stored futures.
 func (s *Store) All() []*Future {
 	s.RLock()
@@ -110,3 +123,23 @@ func (s *Store) Await(timeout time.Duration) error {
 		}
 	}
 }
+
+// AwaitContext will wait until all futures have completed and removed or the
+// context is done.
+func (s *Store) AwaitContext(ctx context.Context) error {
+	for {
+		// get futures
+		futures := s.All()
+
+		// return if no futures are left
+		if len(futures) == 0 {
+			return nil
+		}
+
+		// wait for next future to complete
+		err := futures[0].WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
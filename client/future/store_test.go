@@ -1,6 +1,7 @@
 package future
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -20,6 +21,13 @@ func TestStore(t *testing.T) {
 	store.Delete(1)
 	assert.Nil(t, store.Get(1))
 	assert.Equal(t, 0, len(store.All()))
+
+	store.Put(2, f)
+	store.Remove(New())
+	assert.Equal(t, f, store.Get(2))
+
+	store.Remove(f)
+	assert.Nil(t, store.Get(2))
 }
 
 func TestStoreAwait(t *testing.T) {
@@ -55,3 +63,22 @@ func TestStoreAwaitTimeout(t *testing.T) {
 	err := store.Await(10 * time.Millisecond)
 	assert.Equal(t, ErrTimeout, err)
 }
+
+func TestStoreAwaitContext(t *testing.T) {
+	f := New()
+
+	store := NewStore()
+	store.Put(1, f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := store.AwaitContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	f.Complete()
+	store.Delete(1)
+
+	err = store.AwaitContext(context.Background())
+	assert.NoError(t, err)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"time"
 
 	"github.com/256dpi/gomqtt/client/future"
@@ -15,6 +16,21 @@ type GenericFuture interface {
 	//
 	// Note: Wait will not return any Client related errors.
 	Wait(timeout time.Duration) error
+
+	// WaitContext will block until the future is completed or canceled. It will
+	// return future.ErrCanceled if the future gets canceled. If the context is
+	// done, the error of the context is returned.
+	//
+	// Note: WaitContext will not return any Client related errors.
+	WaitContext(ctx context.Context) error
+
+	// OnComplete will register a callback that is called with nil once the
+	// future is completed or with future.ErrCanceled once it is canceled. The
+	// callback is called immediately if the future is already done.
+	//
+	// Note: The callback is called from the goroutine that completes the future
+	// and should therefore not block.
+	OnComplete(fn func(err error))
 }
 
 // A ConnectFuture is returned by the connect method.
@@ -42,8 +58,24 @@ const (
 	sessionPresentKey futureKey = iota
 	returnCodeKey
 	returnCodesKey
+	retransmissionsKey
+	publishedKey
 )
 
+// increments the number of retransmissions stored in the future
+func countRetransmission(f *future.Future) {
+	v, _ := f.Data.Load(retransmissionsKey)
+	n, _ := v.(int)
+	f.Data.Store(retransmissionsKey, n+1)
+}
+
+// returns the number of retransmissions stored in the future
+func retransmissions(f *future.Future) int {
+	v, _ := f.Data.Load(retransmissionsKey)
+	n, _ := v.(int)
+	return n
+}
+
 type connectFuture struct {
 	*future.Future
 }
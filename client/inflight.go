@@ -0,0 +1,94 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// an inflight keeps track of the send times and retransmissions of outgoing
+// packets that await an acknowledgement
+type inflight struct {
+	sync.Mutex
+
+	packets map[packet.ID]*inflightPacket
+}
+
+type inflightPacket struct {
+	sent     time.Time
+	attempts int
+}
+
+// returns a new inflight
+func newInflight() *inflight {
+	return &inflight{
+		packets: make(map[packet.ID]*inflightPacket),
+	}
+}
+
+// marks the packet as sent and resets its retransmissions
+func (i *inflight) sent(id packet.ID, now time.Time) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.packets[id] = &inflightPacket{sent: now}
+}
+
+// marks the packet as resent and returns the number of retransmissions
+func (i *inflight) resent(id packet.ID, now time.Time) int {
+	i.Lock()
+	defer i.Unlock()
+
+	p, ok := i.packets[id]
+	if !ok {
+		return 0
+	}
+
+	p.sent = now
+	p.attempts++
+
+	return p.attempts
+}
+
+// returns the number of retransmissions of the packet
+func (i *inflight) attempts(id packet.ID) int {
+	i.Lock()
+	defer i.Unlock()
+
+	p, ok := i.packets[id]
+	if !ok {
+		return 0
+	}
+
+	return p.attempts
+}
+
+// removes the packet
+func (i *inflight) remove(id packet.ID) {
+	i.Lock()
+	defer i.Unlock()
+
+	delete(i.packets, id)
+}
+
+// returns the ids of the packets that have been sent before the timeout in
+// the order they have been sent
+func (i *inflight) due(timeout time.Duration, now time.Time) []packet.ID {
+	i.Lock()
+	defer i.Unlock()
+
+	var ids []packet.ID
+	for id, p := range i.packets {
+		if now.Sub(p.sent) >= timeout {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(a, b int) bool {
+		return i.packets[ids[a]].sent.Before(i.packets[ids[b]].sent)
+	})
+
+	return ids
+}
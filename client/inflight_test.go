@@ -0,0 +1,33 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInflight(t *testing.T) {
+	i := newInflight()
+
+	now := time.Now()
+	i.sent(2, now)
+	i.sent(1, now.Add(time.Second))
+
+	assert.Empty(t, i.due(time.Second, now.Add(time.Second/2)))
+	assert.Equal(t, []packet.ID{2}, i.due(time.Second, now.Add(time.Second)))
+	assert.Equal(t, []packet.ID{2, 1}, i.due(time.Second, now.Add(2*time.Second)))
+
+	assert.Equal(t, 1, i.resent(2, now.Add(2*time.Second)))
+	assert.Equal(t, 1, i.attempts(2))
+	assert.Equal(t, []packet.ID{1}, i.due(time.Second, now.Add(2*time.Second)))
+
+	i.sent(2, now.Add(2*time.Second))
+	assert.Equal(t, 0, i.attempts(2))
+
+	i.remove(1)
+	i.remove(2)
+	assert.Empty(t, i.due(time.Second, now.Add(time.Hour)))
+	assert.Equal(t, 0, i.resent(1, now))
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/jpillora/backoff"
+)
+
+// A ReconnectStrategy computes the delays between the connection attempts of a
+// Service to a broker.
+type ReconnectStrategy interface {
+	// Next will return the delay before the next attempt to a broker that
+	// failed the specified number of consecutive times with the specified error.
+	// The previous delay is zero after the first failure and the error may be
+	// nil if the reason of a lost connection is unknown. If an error is
+	// returned the service stops reconnecting until it is restarted and emits
+	// the error using the ErrorCallback.
+	Next(failures int, previous time.Duration, err error) (time.Duration, error)
+}
+
+// ExponentialBackoff doubles the delay after each failure. It is used by the
+// Service if no strategy is set.
+type ExponentialBackoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Next implements the ReconnectStrategy interface.
+func (s *ExponentialBackoff) Next(failures int, previous time.Duration, err error) (time.Duration, error) {
+	b := &backoff.Backoff{
+		Min:    s.Min,
+		Max:    s.Max,
+		Factor: 2,
+	}
+
+	return b.ForAttempt(float64(failures - 1)), nil
+}
+
+// FullJitter picks a random delay between zero and the exponentially growing
+// delay to spread out the reconnects of many clients.
+type FullJitter struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Next implements the ReconnectStrategy interface.
+func (s *FullJitter) Next(failures int, previous time.Duration, err error) (time.Duration, error) {
+	d, _ := (&ExponentialBackoff{Min: s.Min, Max: s.Max}).Next(failures, previous, err)
+	return randomDuration(0, d), nil
+}
+
+// DecorrelatedJitter picks a random delay between the minimum and three times
+// the previous delay. The delays grow more slowly than with FullJitter but are
+// less correlated between clients.
+type DecorrelatedJitter struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Next implements the ReconnectStrategy interface.
+func (s *DecorrelatedJitter) Next(failures int, previous time.Duration, err error) (time.Duration, error) {
+	// pick delay
+	d := s.Min
+	if previous > 0 {
+		d = randomDuration(s.Min, previous*3)
+	}
+
+	// apply maximum
+	if d > s.Max {
+		d = s.Max
+	}
+
+	return d, nil
+}
+
+// A CircuitOpenError is returned by a CircuitBreaker if the threshold of
+// consecutive authorization failures has been reached.
+type CircuitOpenError struct {
+	Failures int
+	Err      error
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open after %d authorization failures: %s", e.Failures, e.Err.Error())
+}
+
+// A CircuitBreaker wraps another strategy and stops reconnecting once the
+// brokers rejected the specified number of consecutive connection attempts with
+// packet.ErrNotAuthorized or packet.ErrBadUsernameOrPassword, as retrying will
+// most likely not succeed without changing the credentials.
+//
+// Note: A CircuitBreaker must not be shared between services.
+type CircuitBreaker struct {
+	// The strategy used to compute the delays.
+	Strategy ReconnectStrategy
+
+	// The number of consecutive authorization failures that opens the circuit.
+	Threshold int
+
+	failures int
+	mutex    sync.Mutex
+}
+
+// Next implements the ReconnectStrategy interface.
+func (s *CircuitBreaker) Next(failures int, previous time.Duration, err error) (time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// count consecutive authorization failures
+	if err == packet.ErrNotAuthorized || err == packet.ErrBadUsernameOrPassword {
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+
+	// open circuit if threshold has been reached
+	if s.Threshold > 0 && s.failures >= s.Threshold {
+		return 0, &CircuitOpenError{
+			Failures: s.failures,
+			Err:      err,
+		}
+	}
+
+	return s.Strategy.Next(failures, previous, err)
+}
+
+// returns a random duration between min and max
+func randomDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+
+	return min + time.Duration(rand.Int63n(int64(max-min)))
+}
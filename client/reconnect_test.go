@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	s := &ExponentialBackoff{Min: time.Second, Max: 4 * time.Second}
+
+	var delays []time.Duration
+	for i := 1; i <= 4; i++ {
+		d, err := s.Next(i, 0, nil)
+		assert.NoError(t, err)
+		delays = append(delays, d)
+	}
+
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}, delays)
+}
+
+func TestFullJitter(t *testing.T) {
+	s := &FullJitter{Min: time.Second, Max: 4 * time.Second}
+
+	for i := 1; i <= 10; i++ {
+		d, err := s.Next(i, 0, nil)
+		assert.NoError(t, err)
+		assert.True(t, d >= 0)
+		assert.True(t, d < 4*time.Second)
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	s := &DecorrelatedJitter{Min: time.Second, Max: 10 * time.Second}
+
+	d, err := s.Next(1, 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, d)
+
+	for i := 2; i <= 10; i++ {
+		prev := d
+		d, err = s.Next(i, prev, nil)
+		assert.NoError(t, err)
+		assert.True(t, d >= time.Second)
+		assert.True(t, d <= 10*time.Second)
+		assert.True(t, d <= prev*3)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s := &CircuitBreaker{
+		Strategy:  &ExponentialBackoff{Min: time.Second, Max: 4 * time.Second},
+		Threshold: 2,
+	}
+
+	d, err := s.Next(1, 0, packet.ErrNotAuthorized)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, d)
+
+	d, err = s.Next(2, d, errors.New("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, d)
+
+	_, err = s.Next(3, d, packet.ErrBadUsernameOrPassword)
+	assert.NoError(t, err)
+
+	_, err = s.Next(4, d, packet.ErrNotAuthorized)
+	assert.Equal(t, &CircuitOpenError{
+		Failures: 2,
+		Err:      packet.ErrNotAuthorized,
+	}, err)
+}
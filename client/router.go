@@ -0,0 +1,189 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// Params holds the values of the named segments captured from a topic.
+type Params map[string]string
+
+// A Handler is called by the Router with a received message and the values of
+// the named segments captured from the messages topic. If an error is returned
+// the underlying client will be prevented from acknowledging the message and
+// closes immediately.
+type Handler func(msg *packet.Message, params Params) error
+
+type route struct {
+	pattern string
+	filter  string
+	names   map[int]string
+	qos     uint8
+	handler Handler
+}
+
+// A Router dispatches the messages received by a Service to the handlers
+// registered for matching topic filters. Filters may contain the usual
+// wildcards and named segments in the form of "devices/{id}/telemetry" that
+// match a single level and are captured in the Params passed to the handler.
+type Router struct {
+	service *Service
+	tree    *topic.Tree
+	routes  []*route
+	mutex   sync.Mutex
+}
+
+// NewRouter will create and return a new Router that takes over the
+// MessageCallback of the specified service. The subscriptions issued by the
+// router are restored by the service after a reconnect.
+func NewRouter(service *Service) *Router {
+	r := &Router{
+		service: service,
+		tree:    topic.NewTree(),
+	}
+
+	// set message callback
+	service.MessageCallback = r.Dispatch
+
+	return r
+}
+
+// Handle will register the handler for the specified pattern and issue the
+// corresponding subscription. It will return a SubscribeFuture that gets
+// completed once the subscription has been acknowledged.
+//
+// Note: The method will panic if the pattern is invalid.
+func (r *Router) Handle(pattern string, qos uint8, handler Handler) SubscribeFuture {
+	// parse pattern
+	filter, names, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("invalid pattern %q: %s", pattern, err.Error()))
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// add route
+	rt := &route{
+		pattern: pattern,
+		filter:  filter,
+		names:   names,
+		qos:     qos,
+		handler: handler,
+	}
+	r.routes = append(r.routes, rt)
+	r.tree.Add(filter, rt)
+
+	return r.service.Subscribe(filter, uint8(r.maxQOS(filter)))
+}
+
+// Remove will remove all handlers registered for the specified pattern and
+// unsubscribe the corresponding filter if it is not used by other routes. It
+// will return a GenericFuture that gets completed once the unsubscription has
+// been acknowledged or nil if no unsubscription was necessary.
+func (r *Router) Remove(pattern string) GenericFuture {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// remove routes
+	var filter string
+	routes := make([]*route, 0, len(r.routes))
+	for _, rt := range r.routes {
+		if rt.pattern == pattern {
+			filter = rt.filter
+			r.tree.Remove(rt.filter, rt)
+			continue
+		}
+
+		routes = append(routes, rt)
+	}
+	r.routes = routes
+
+	// check if filter is still used
+	if filter == "" || r.maxQOS(filter) >= 0 {
+		return nil
+	}
+
+	return r.service.Unsubscribe(filter)
+}
+
+// Dispatch will call all handlers that match the topic of the message in the
+// order they have been registered. It is set as the MessageCallback of the
+// service by NewRouter.
+func (r *Router) Dispatch(msg *packet.Message) error {
+	// get matching routes
+	r.mutex.Lock()
+	matches := r.tree.Match(msg.Topic)
+	routes := make([]*route, 0, len(matches))
+	for _, rt := range r.routes {
+		for _, match := range matches {
+			if match == rt {
+				routes = append(routes, rt)
+				break
+			}
+		}
+	}
+	r.mutex.Unlock()
+
+	// split topic
+	segments := strings.Split(msg.Topic, "/")
+
+	// call handlers
+	for _, rt := range routes {
+		// capture params
+		params := make(Params, len(rt.names))
+		for i, name := range rt.names {
+			if i < len(segments) {
+				params[name] = segments[i]
+			}
+		}
+
+		// call handler
+		err := rt.handler(msg, params)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns the highest qos of all routes using the filter or -1
+func (r *Router) maxQOS(filter string) int {
+	qos := -1
+	for _, rt := range r.routes {
+		if rt.filter == filter && int(rt.qos) > qos {
+			qos = int(rt.qos)
+		}
+	}
+
+	return qos
+}
+
+// parses a pattern and returns the filter and the positions of named segments
+func parsePattern(pattern string) (string, map[int]string, error) {
+	// convert named segments
+	segments := strings.Split(pattern, "/")
+	names := make(map[int]string)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && len(segment) > 2 {
+			names[i] = segment[1 : len(segment)-1]
+			segments[i] = "+"
+		}
+	}
+
+	// validate filter
+	filter := strings.Join(segments, "/")
+	normalized, err := topic.Parse(filter, true)
+	if err != nil {
+		return "", nil, err
+	} else if normalized != filter {
+		return "", nil, fmt.Errorf("not normalized")
+	}
+
+	return filter, names, nil
+}
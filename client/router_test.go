@@ -0,0 +1,121 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePattern(t *testing.T) {
+	filter, names, err := parsePattern("devices/{id}/{kind}/#")
+	assert.NoError(t, err)
+	assert.Equal(t, "devices/+/+/#", filter)
+	assert.Equal(t, map[int]string{1: "id", 2: "kind"}, names)
+
+	filter, names, err = parsePattern("foo/+")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo/+", filter)
+	assert.Empty(t, names)
+
+	_, _, err = parsePattern("foo/#/bar")
+	assert.Error(t, err)
+
+	_, _, err = parsePattern("foo/")
+	assert.Error(t, err)
+}
+
+func TestRouterDispatch(t *testing.T) {
+	r := NewRouter(NewService())
+
+	var calls []string
+
+	r.Handle("devices/{id}/telemetry", 0, func(msg *packet.Message, params Params) error {
+		calls = append(calls, "telemetry:"+params["id"])
+		return nil
+	})
+
+	r.Handle("devices/#", 0, func(msg *packet.Message, params Params) error {
+		assert.Empty(t, params)
+		calls = append(calls, "all")
+		return nil
+	})
+
+	assert.NoError(t, r.Dispatch(&packet.Message{Topic: "devices/d1/telemetry"}))
+	assert.NoError(t, r.Dispatch(&packet.Message{Topic: "devices/d1/status"}))
+	assert.NoError(t, r.Dispatch(&packet.Message{Topic: "other"}))
+	assert.Equal(t, []string{"telemetry:d1", "all", "all"}, calls)
+
+	assert.Nil(t, r.Remove("foo"))
+	assert.NotNil(t, r.Remove("devices/#"))
+
+	calls = nil
+	assert.NoError(t, r.Dispatch(&packet.Message{Topic: "devices/d1/status"}))
+	assert.Empty(t, calls)
+}
+
+func TestRouterRestore(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "devices/+/telemetry", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1}
+	suback.ID = 1
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "devices/d1/telemetry"
+	publish.Message.Payload = []byte("test")
+
+	received := make(chan struct{})
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Send(publish).
+		Wait(received).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online := make(chan struct{}, 2)
+
+	s := NewService()
+	s.MinReconnectDelay = 50 * time.Millisecond
+
+	s.OnlineCallback = func(resumed bool) {
+		online <- struct{}{}
+	}
+
+	r := NewRouter(s)
+
+	sf := r.Handle("devices/{id}/telemetry", 1, func(msg *packet.Message, params Params) error {
+		assert.Equal(t, "d1", params["id"])
+		close(received)
+		return nil
+	})
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.NoError(t, sf.Wait(time.Second))
+	assert.Equal(t, []uint8{1}, sf.ReturnCodes())
+
+	<-online
+	<-online
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
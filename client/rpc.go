@@ -0,0 +1,324 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrInvalidEnvelope is returned when a payload cannot be decoded as an
+// Envelope.
+var ErrInvalidEnvelope = errors.New("invalid envelope")
+
+// the message sent for handler errors that have an empty message
+const unknownRPCError = "unknown error"
+
+// An RPCError is returned by Call if the remote handler returned an error.
+type RPCError struct {
+	Message string
+}
+
+// Error implements the error interface.
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// An Envelope wraps the payload of requests and responses exchanged by a Caller
+// and a Responder. MQTT 3.1.1 has no properties to carry the reply topic and the
+// correlation id, therefore they are encoded in front of the payload.
+//
+// The encoding consists of the length prefixed reply topic, correlation id and
+// error followed by the raw payload. The lengths are encoded as two byte big
+// endian integers like strings in MQTT packets.
+type Envelope struct {
+	// The topic the response should be published to.
+	ReplyTo string
+
+	// The id that is used to match the response to the request.
+	CorrelationID string
+
+	// The error returned by the remote handler.
+	Error string
+
+	// The payload of the request or response.
+	Payload []byte
+}
+
+// Encode will encode the envelope.
+func (e *Envelope) Encode() ([]byte, error) {
+	// check lengths
+	if len(e.ReplyTo) > 65535 || len(e.CorrelationID) > 65535 || len(e.Error) > 65535 {
+		return nil, ErrInvalidEnvelope
+	}
+
+	// allocate buffer
+	buf := make([]byte, 6+len(e.ReplyTo)+len(e.CorrelationID)+len(e.Error)+len(e.Payload))
+
+	// write fields
+	n := writeEnvelopeString(buf, e.ReplyTo)
+	n += writeEnvelopeString(buf[n:], e.CorrelationID)
+	n += writeEnvelopeString(buf[n:], e.Error)
+	copy(buf[n:], e.Payload)
+
+	return buf, nil
+}
+
+// Decode will decode the envelope from the specified bytes.
+func (e *Envelope) Decode(src []byte) error {
+	var fields [3]string
+
+	// read fields
+	for i := range fields {
+		str, n, err := readEnvelopeString(src)
+		if err != nil {
+			return err
+		}
+
+		fields[i] = str
+		src = src[n:]
+	}
+
+	// set fields
+	e.ReplyTo = fields[0]
+	e.CorrelationID = fields[1]
+	e.Error = fields[2]
+	e.Payload = src
+
+	return nil
+}
+
+func writeEnvelopeString(buf []byte, str string) int {
+	binary.BigEndian.PutUint16(buf, uint16(len(str)))
+	copy(buf[2:], str)
+	return 2 + len(str)
+}
+
+func readEnvelopeString(src []byte) (string, int, error) {
+	// check length
+	if len(src) < 2 {
+		return "", 0, ErrInvalidEnvelope
+	}
+
+	// read string
+	l := int(binary.BigEndian.Uint16(src))
+	if len(src) < 2+l {
+		return "", 0, ErrInvalidEnvelope
+	}
+
+	return string(src[2 : 2+l]), 2 + l, nil
+}
+
+// A Caller publishes requests to topics handled by a Responder and waits for
+// the matching responses on its reply topic.
+type Caller struct {
+	router          *Router
+	replyTo         string
+	prefix          string
+	counter         uint64
+	subscribeFuture SubscribeFuture
+
+	pending map[string]chan *Envelope
+	mutex   sync.Mutex
+
+	// The QOS level used to publish requests. The responses are published with
+	// the QOS level the request has been received with by the responder, which
+	// is the lower of this level and the level of the responder subscription.
+	QOS uint8
+}
+
+// NewCaller will create and return a new Caller that receives responses on the
+// specified reply topic using the router. The reply topic should be unique to
+// the caller.
+func NewCaller(router *Router, replyTo string) *Caller {
+	c := &Caller{
+		router:  router,
+		replyTo: replyTo,
+		prefix:  randomPrefix(),
+		pending: make(map[string]chan *Envelope),
+		QOS:     1,
+	}
+
+	// subscribe reply topic
+	c.subscribeFuture = router.Handle(replyTo, 1, c.handle)
+
+	return c
+}
+
+// Call will publish a request with the payload to the specified topic and wait
+// for the response or until the context is done. If the remote handler
+// returned an error an RPCError is returned.
+func (c *Caller) Call(ctx context.Context, topic string, payload []byte) ([]byte, error) {
+	// wait for subscription
+	err := c.subscribeFuture.WaitContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare envelope
+	env := &Envelope{
+		ReplyTo:       c.replyTo,
+		CorrelationID: c.prefix + strconv.FormatUint(atomic.AddUint64(&c.counter, 1), 10),
+		Payload:       payload,
+	}
+
+	// encode envelope
+	buf, err := env.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// register request
+	ch := make(chan *Envelope, 1)
+	c.mutex.Lock()
+	c.pending[env.CorrelationID] = ch
+	c.mutex.Unlock()
+
+	// ensure request is removed
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, env.CorrelationID)
+		c.mutex.Unlock()
+	}()
+
+	// publish request
+	c.router.service.Publish(topic, buf, c.QOS, false)
+
+	// wait for response
+	select {
+	case res := <-ch:
+		if res.Error != "" {
+			return nil, &RPCError{Message: res.Error}
+		}
+
+		return res.Payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Caller) handle(msg *packet.Message, params Params) error {
+	// decode envelope and ignore invalid responses
+	var env Envelope
+	if env.Decode(msg.Payload) != nil {
+		return nil
+	}
+
+	// get pending request
+	c.mutex.Lock()
+	ch, ok := c.pending[env.CorrelationID]
+	delete(c.pending, env.CorrelationID)
+	c.mutex.Unlock()
+
+	// deliver response
+	if ok {
+		ch <- &env
+	}
+
+	return nil
+}
+
+// An RPCHandler is called by a Responder with the payload of a request and the
+// values of the named segments captured from the request topic. The returned
+// payload or the message of the returned error is sent back to the caller.
+//
+// Note: Execution of the service is resumed after the handler returns. This
+// means that waiting on a future inside the handler will deadlock the service.
+type RPCHandler func(payload []byte, params Params) ([]byte, error)
+
+// A Responder subscribes to request topics and publishes the results of the
+// registered handlers to the reply topics of the requests.
+type Responder struct {
+	router *Router
+}
+
+// NewResponder will create and return a new Responder that uses the router to
+// receive requests.
+func NewResponder(router *Router) *Responder {
+	return &Responder{
+		router: router,
+	}
+}
+
+// Handle will register the handler for requests received on the specified
+// pattern. The pattern may contain named segments like patterns passed to
+// Router.Handle. It will return a SubscribeFuture that gets completed once the
+// subscription has been acknowledged. Responses are published with the QOS
+// level of the received request.
+func (r *Responder) Handle(pattern string, qos uint8, handler RPCHandler) SubscribeFuture {
+	return r.router.Handle(pattern, qos, func(msg *packet.Message, params Params) error {
+		// decode envelope and ignore invalid requests
+		var req Envelope
+		if req.Decode(msg.Payload) != nil || req.ReplyTo == "" {
+			return nil
+		}
+
+		// call handler
+		payload, err := handler(req.Payload, params)
+
+		// prepare response
+		res := &Envelope{
+			CorrelationID: req.CorrelationID,
+			Payload:       payload,
+		}
+		if err != nil {
+			res.Error = rpcErrorMessage(err)
+			res.Payload = nil
+		}
+
+		// encode response
+		buf, err := res.Encode()
+		if err != nil {
+			return nil
+		}
+
+		// publish response
+		r.router.service.Publish(req.ReplyTo, buf, msg.QOS, false)
+
+		return nil
+	})
+}
+
+// returns a non empty error message that fits into an envelope
+func rpcErrorMessage(err error) string {
+	// get message
+	msg := err.Error()
+	if msg == "" {
+		return unknownRPCError
+	}
+
+	// truncate message on a rune boundary
+	if len(msg) > 65535 {
+		n := 65535
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+
+		msg = msg[:n]
+	}
+
+	return msg
+}
+
+// returns a random prefix for correlation ids
+func randomPrefix() string {
+	return randomID() + "-"
+}
+
+// returns a random hex encoded id
+func randomID() string {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(buf)
+}
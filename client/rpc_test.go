@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeEnvelope(env *Envelope) []byte {
+	buf, err := env.Encode()
+	if err != nil {
+		panic(err)
+	}
+
+	return buf
+}
+
+func TestEnvelope(t *testing.T) {
+	env := &Envelope{
+		ReplyTo:       "reply",
+		CorrelationID: "1",
+		Error:         "error",
+		Payload:       []byte("payload"),
+	}
+
+	buf, err := env.Encode()
+	assert.NoError(t, err)
+
+	var env2 Envelope
+	assert.NoError(t, env2.Decode(buf))
+	assert.Equal(t, env, &env2)
+
+	assert.Equal(t, ErrInvalidEnvelope, env2.Decode([]byte{0}))
+	assert.Equal(t, ErrInvalidEnvelope, env2.Decode([]byte{0, 5, 'a'}))
+}
+
+func TestCaller(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "reply", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1}
+	suback.ID = 1
+
+	request := packet.NewPublishPacket()
+	request.Message.Topic = "rpc"
+	request.Message.QOS = 1
+	request.Message.Payload = encodeEnvelope(&Envelope{
+		ReplyTo:       "reply",
+		CorrelationID: "p-1",
+		Payload:       []byte("ping"),
+	})
+	request.ID = 2
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 2
+
+	response := packet.NewPublishPacket()
+	response.Message.Topic = "reply"
+	response.Message.Payload = encodeEnvelope(&Envelope{
+		CorrelationID: "p-1",
+		Payload:       []byte("pong"),
+	})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(request).
+		Send(puback).
+		Send(response).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	c := NewCaller(NewRouter(s), "reply")
+	c.prefix = "p-"
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	payload, err := c.Call(ctx, "rpc", []byte("ping"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pong"), payload)
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestResponder(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "rpc/+", QOS: 0}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{0}
+	suback.ID = 1
+
+	request1 := packet.NewPublishPacket()
+	request1.Message.Topic = "rpc/foo"
+	request1.Message.Payload = encodeEnvelope(&Envelope{
+		ReplyTo:       "reply",
+		CorrelationID: "1",
+		Payload:       []byte("ping"),
+	})
+
+	response1 := packet.NewPublishPacket()
+	response1.Message.Topic = "reply"
+	response1.Message.Payload = encodeEnvelope(&Envelope{
+		CorrelationID: "1",
+		Payload:       []byte("pong:foo"),
+	})
+
+	request2 := packet.NewPublishPacket()
+	request2.Message.Topic = "rpc/bar"
+	request2.Message.Payload = encodeEnvelope(&Envelope{
+		ReplyTo:       "reply",
+		CorrelationID: "2",
+		Payload:       []byte("fail"),
+	})
+
+	response2 := packet.NewPublishPacket()
+	response2.Message.Topic = "reply"
+	response2.Message.Payload = encodeEnvelope(&Envelope{
+		CorrelationID: "2",
+		Error:         "failed",
+	})
+
+	request3 := packet.NewPublishPacket()
+	request3.Message.Topic = "rpc/baz"
+	request3.Message.Payload = encodeEnvelope(&Envelope{
+		ReplyTo:       "reply",
+		CorrelationID: "3",
+		Payload:       []byte("empty"),
+	})
+
+	response3 := packet.NewPublishPacket()
+	response3.Message.Topic = "reply"
+	response3.Message.Payload = encodeEnvelope(&Envelope{
+		CorrelationID: "3",
+		Error:         unknownRPCError,
+	})
+
+	responded := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Send(request1).
+		Receive(response1).
+		Send(request2).
+		Receive(response2).
+		Send(request3).
+		Receive(response3).
+		Run(func() {
+			close(responded)
+		}).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	r := NewResponder(NewRouter(s))
+
+	r.Handle("rpc/{name}", 0, func(payload []byte, params Params) ([]byte, error) {
+		switch string(payload) {
+		case "fail":
+			return nil, errors.New("failed")
+		case "empty":
+			return nil, errors.New("")
+		}
+
+		return []byte("pong:" + params["name"]), nil
+	})
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(responded)
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestRPCErrorMessage(t *testing.T) {
+	assert.Equal(t, "failed", rpcErrorMessage(errors.New("failed")))
+	assert.Equal(t, unknownRPCError, rpcErrorMessage(errors.New("")))
+
+	msg := rpcErrorMessage(errors.New(strings.Repeat("x", 65534) + "ü"))
+	assert.Equal(t, strings.Repeat("x", 65534), msg)
+
+	_, err := (&Envelope{Error: msg}).Encode()
+	assert.NoError(t, err)
+}
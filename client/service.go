@@ -8,7 +8,6 @@ import (
 
 	"github.com/256dpi/gomqtt/client/future"
 	"github.com/256dpi/gomqtt/packet"
-	"github.com/jpillora/backoff"
 	"github.com/rigoiot/gomqtt/session"
 	"gopkg.in/tomb.v2"
 )
@@ -25,6 +24,8 @@ type command struct {
 }
 
 // An OnlineCallback is a function that is called when the service is connected.
+// The URL of the broker the service is connected to is available using
+// Service.BrokerURL.
 //
 // Note: Execution of the service is resumed after the callback returns. This
 // means that waiting on a future inside the callback will deadlock the service.
@@ -61,6 +62,19 @@ const (
 // All methods return Futures that get completed once the acknowledgements are
 // received. Once the services is stopped all waiting futures get canceled.
 //
+// The service keeps track of the subscriptions issued through Subscribe,
+// SubscribeMultiple, Unsubscribe and UnsubscribeMultiple and will replay them
+// after a reconnect if the broker reports that no session is present. Pending
+// subscribe futures are then completed with the results of the replay and
+// pending unsubscribe futures are completed immediately.
+//
+// If a Buffer is set, published messages are stored in the buffer instead of
+// the command queue and handed over to the client in order once the service is
+// online. If the buffer is full the DropPolicy decides whether the new or the
+// oldest message is dropped. The future of a dropped message gets canceled.
+// Buffered messages are kept when the service is stopped and their futures are
+// completed once they have been published after the service is started again.
+//
 // Note: If clean session is false and there are packets in the store, messages
 // might get completed after starting without triggering any futures to complete.
 type Service struct {
@@ -68,7 +82,9 @@ type Service struct {
 
 	config *Config
 
-	backoff *backoff.Backoff
+	pool *brokerPool
+
+	brokerURL atomic.Value
 
 	// The session used by the client to store unacknowledged packets.
 	Session Session
@@ -90,24 +106,94 @@ type Service struct {
 	// automatic keep alive handler, reconnection and occurring errors.
 	Logger Logger
 
-	// The minimum delay between reconnects.
+	// The minimum delay between reconnects to the same broker.
 	//
 	// Note: The value must be changed before calling Start.
 	MinReconnectDelay time.Duration
 
-	// The maximum delay between reconnects.
+	// The maximum delay between reconnects to the same broker.
 	//
 	// Note: The value must be changed before calling Start.
 	MaxReconnectDelay time.Duration
 
+	// The duration before the expiry of the credentials returned by the
+	// CredentialsProvider of the config at which the service proactively
+	// reconnects to fetch fresh credentials. Zero disables proactive reconnects.
+	ReconnectBeforeExpiry time.Duration
+
+	// The strategy used to compute the delays between reconnects to the same
+	// broker. It defaults to an ExponentialBackoff between MinReconnectDelay
+	// and MaxReconnectDelay.
+	//
+	// Note: The value must be changed before calling Start.
+	ReconnectStrategy ReconnectStrategy
+
 	// The allowed timeout until a connection attempt is canceled.
 	ConnectTimeout time.Duration
 
 	// The allowed timeout until a connection is forcefully closed.
 	DisconnectTimeout time.Duration
 
+	// The buffer used to store published messages until they are handed over
+	// to a client.
+	//
+	// Note: The value must be changed before calling Start.
+	Buffer Buffer
+
+	// The policy applied if the buffer is full.
+	DropPolicy DropPolicy
+
+	// The number of workers that call the MessageCallback concurrently. If
+	// greater than one, incoming messages are distributed to the workers using
+	// the OrderingKey and acknowledged once the callback returned. If the queue
+	// of a worker is full, the client stops reading incoming packets.
+	//
+	// Note: The value must be changed before calling Start.
+	Workers int
+
+	// The number of messages that can be queued per worker.
+	//
+	// Note: The value must be changed before calling Start.
+	WorkerQueueSize int
+
+	// The function used to derive the key that orders the processing of
+	// messages. It defaults to TopicKey.
+	//
+	// Note: The value must be changed before calling Start.
+	OrderingKey KeyFunc
+
+	// The deduplicator used by all clients to suppress redelivered QOS 1
+	// messages.
+	//
+	// Note: The value must be changed before calling Start.
+	Deduplicator *Deduplicator
+
+	// The duration after which clients resend unacknowledged packets. Zero
+	// disables retransmissions.
+	RetransmitTimeout time.Duration
+
+	// The maximum number of retransmissions of a single packet before the
+	// client is reconnected. Zero disables the limit.
+	MaxRetransmits int
+
+	// The channel that receives a DeliveryReport for every message published
+	// using Publish or PublishMessage once its future is completed or
+	// canceled. Reports are dropped if the channel is full.
+	//
+	// Note: The value must be changed before calling Start.
+	DeliveryReports chan<- *DeliveryReport
+
 	commandQueue chan *command
 	futureStore  *future.Store
+	stats        *statistics
+
+	subscriptions []packet.Subscription
+	pending       map[*future.Future]*command
+	pendingMutex  sync.Mutex
+
+	buffered     []*future.Future
+	bufferSignal chan struct{}
+	bufferMutex  sync.Mutex
 
 	mutex sync.Mutex
 	tomb  *tomb.Tomb
@@ -131,6 +217,9 @@ func NewService(queueSize ...int) *Service {
 		DisconnectTimeout: 10 * time.Second,
 		commandQueue:      make(chan *command, qs),
 		futureStore:       future.NewStore(),
+		stats:             newStatistics(),
+		pending:           make(map[*future.Future]*command),
+		bufferSignal:      make(chan struct{}, 1),
 	}
 }
 
@@ -155,13 +244,24 @@ func (s *Service) Start(config *Config) {
 	// save config
 	s.config = config
 
-	// initialize backoff
-	s.backoff = &backoff.Backoff{
-		Min:    s.MinReconnectDelay,
-		Max:    s.MaxReconnectDelay,
-		Factor: 2,
+	// get broker urls
+	urls := config.BrokerURLs
+	if len(urls) == 0 {
+		urls = []string{config.BrokerURL}
+	}
+
+	// get reconnect strategy
+	strategy := s.ReconnectStrategy
+	if strategy == nil {
+		strategy = &ExponentialBackoff{
+			Min: s.MinReconnectDelay,
+			Max: s.MaxReconnectDelay,
+		}
 	}
 
+	// initialize broker pool
+	s.pool = newBrokerPool(urls, config.BrokerStrategy, strategy)
+
 	// mark future store as protected
 	s.futureStore.Protect(true)
 
@@ -190,6 +290,11 @@ func (s *Service) Publish(topic string, payload []byte, qos uint8, retain bool)
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
 func (s *Service) PublishMessage(msg *packet.Message) GenericFuture {
+	// buffer message if a buffer is set
+	if s.Buffer != nil {
+		return s.report(msg, s.buffer(msg))
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -203,7 +308,7 @@ func (s *Service) PublishMessage(msg *packet.Message) GenericFuture {
 		message: msg,
 	}
 
-	return f
+	return s.report(msg, f)
 }
 
 // Subscribe will send a SubscribePacket containing one topic to subscribe. It
@@ -262,8 +367,37 @@ func (s *Service) UnsubscribeMultiple(topics []string) GenericFuture {
 	return f
 }
 
+// Stats will return a snapshot of the keep alive and connection quality
+// statistics of all clients used by the service.
+func (s *Service) Stats() Stats {
+	return s.stats.snapshot()
+}
+
+// QueueDepth returns the number of buffered messages if a buffer is set and the
+// number of queued commands otherwise.
+func (s *Service) QueueDepth() int {
+	// check buffer
+	if s.Buffer != nil {
+		s.bufferMutex.Lock()
+		defer s.bufferMutex.Unlock()
+
+		return s.Buffer.Len()
+	}
+
+	return len(s.commandQueue)
+}
+
+// BrokerURL will return the URL of the broker the service is currently
+// connected to or an empty string if the service is offline.
+func (s *Service) BrokerURL() string {
+	url, _ := s.brokerURL.Load().(string)
+	return url
+}
+
 // Stop will disconnect the client if online and cancel all futures if requested.
-// After the service is stopped in can be started again.
+// After the service is stopped in can be started again. The futures of
+// messages that remain in the Buffer are never canceled as the messages are
+// published once the service is started again.
 //
 // Note: You should clear the futures on the last stop before exiting to ensure
 // that all goroutines return that wait on futures.
@@ -292,15 +426,10 @@ func (s *Service) Stop(clearFutures bool) {
 
 // the supervised reconnect loop
 func (s *Service) supervisor() error {
-	first := true
-
 	for {
-		if first {
-			// no delay on first attempt
-			first = false
-		} else {
-			// get backoff duration
-			d := s.backoff.Duration()
+		// pick next broker
+		broker, d := s.pool.pick(time.Now())
+		if d > 0 {
 			s.log(fmt.Sprintf("Delay Reconnect: %v", d))
 
 			// sleep but return on Stop
@@ -315,20 +444,74 @@ func (s *Service) supervisor() error {
 
 		// prepare the stop channel
 		fail := make(chan struct{})
+		var lost error
+
+		// prepare the worker pool
+		var workers *workerPool
+		if s.Workers > 1 {
+			workers = newWorkerPool(s.Workers, s.WorkerQueueSize, s.callback, s.OrderingKey)
+		}
 
 		// try once to get a client
-		client, resumed := s.connect(fail)
-		if client == nil {
+		client, resumed, err := s.connect(fail, &lost, broker.url, workers)
+		if err != nil {
+			s.err("Connect", err)
+			s.stats.reconnect(err)
+			workers.stop()
+
+			err = s.pool.failure(broker, time.Now(), err)
+			s.log(fmt.Sprintf("Broker Failures: %s (%d)", broker.url, broker.failures))
+			if err != nil {
+				return s.abort(err)
+			}
+
 			continue
 		}
 
+		// mark broker as healthy
+		s.pool.success(broker)
+
+		// restore subscriptions if the session is not present
+		if !resumed {
+			err := s.restore(client)
+			if err != nil {
+				s.err("Restore", err)
+				s.stats.reconnect(err)
+				client.Close()
+				workers.stop()
+
+				err = s.pool.failure(broker, time.Now(), err)
+				if err != nil {
+					return s.abort(err)
+				}
+
+				continue
+			}
+		}
+
+		// set broker url
+		s.brokerURL.Store(broker.url)
+
 		// run callback
 		if s.OnlineCallback != nil {
 			s.OnlineCallback(resumed)
 		}
 
+		// schedule proactive reconnect
+		refresh := make(chan struct{})
+		timer := s.schedule(client, refresh)
+
 		// run dispatcher on client
-		dying := s.dispatcher(client, fail)
+		dying := s.dispatcher(client, fail, refresh)
+
+		// stop timer and workers
+		if timer != nil {
+			timer.Stop()
+		}
+		workers.stop()
+
+		// clear broker url
+		s.brokerURL.Store("")
 
 		// run callback
 		if s.OfflineCallback != nil {
@@ -339,38 +522,79 @@ func (s *Service) supervisor() error {
 		if dying {
 			return tomb.ErrDying
 		}
+
+		// reconnect immediately after a proactive reconnect unless the client
+		// failed meanwhile
+		if !closed(fail) && closed(refresh) {
+			continue
+		}
+
+		// get the reason if the client failed
+		var reason error
+		if closed(fail) {
+			reason = lost
+		}
+
+		// count reconnect
+		s.stats.reconnect(reason)
+
+		// delay the next attempt to the same broker
+		err = s.pool.failure(broker, time.Now(), reason)
+		if err != nil {
+			return s.abort(err)
+		}
 	}
 }
 
+// emits the error of the reconnect strategy and stops reconnecting
+func (s *Service) abort(err error) error {
+	s.err("Reconnect", err)
+	s.log("Abort Reconnect")
+
+	return err
+}
+
 // will try to connect one client to the broker
-func (s *Service) connect(fail chan struct{}) (*Client, bool) {
+func (s *Service) connect(fail chan struct{}, lost *error, url string, workers *workerPool) (*Client, bool, error) {
 	// prepare new client
 	client := New()
 	client.Session = s.Session
 	client.Logger = s.Logger
 	client.futureStore = s.futureStore
+	client.stats = s.stats
+	client.Deduplicator = s.Deduplicator
+	client.RetransmitTimeout = s.RetransmitTimeout
+	client.MaxRetransmits = s.MaxRetransmits
+
+	// acknowledge messages once handled by the workers
+	client.ManualAck = workers != nil
 
 	// set callback
 	client.Callback = func(msg *packet.Message, err error) error {
 		if err != nil {
 			s.err("Client", err)
+			*lost = err
 			close(fail)
 			return nil
 		}
 
-		// call the handler
-		if s.MessageCallback != nil {
-			return s.MessageCallback(msg)
+		// dispatch message to workers
+		if workers != nil {
+			workers.dispatch(client, msg)
+			return nil
 		}
 
-		return nil
+		return s.callback(msg)
 	}
 
+	// prepare config
+	config := *s.config
+	config.BrokerURL = url
+
 	// attempt to connect
-	connectFuture, err := client.Connect(s.config)
+	connectFuture, err := client.Connect(&config)
 	if err != nil {
-		s.err("Connect", err)
-		return nil, false
+		return nil, false, err
 	}
 
 	// wait for connack
@@ -378,31 +602,40 @@ func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 
 	// check if future has been canceled
 	if err == future.ErrCanceled {
-		s.err("Connect", err)
-		return nil, false
+		// return the return code if the connection has been denied
+		if connectFuture.ReturnCode() != packet.ConnectionAccepted {
+			return nil, false, connectFuture.ReturnCode()
+		}
+
+		return nil, false, err
 	}
 
 	// check if future has timed out
 	if err == future.ErrTimeout {
 		client.Close()
 
-		s.err("Connect", err)
-		return nil, false
+		return nil, false, err
 	}
 
 	// check return code
 	if connectFuture.ReturnCode() != packet.ConnectionAccepted {
 		client.Close()
 
-		s.err("Connect", connectFuture.ReturnCode())
-		return nil, false
+		return nil, false, connectFuture.ReturnCode()
 	}
 
-	return client, connectFuture.SessionPresent()
+	return client, connectFuture.SessionPresent(), nil
 }
 
 // reads from the queues and calls the current client
-func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
+func (s *Service) dispatcher(client *Client, fail, refresh chan struct{}) bool {
+	// hand over messages buffered while offline
+	err := s.flush(client)
+	if err != nil {
+		s.err("Publish", err)
+		return false
+	}
+
 	for {
 		select {
 		case cmd := <-s.commandQueue:
@@ -419,9 +652,12 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 					return false
 				}
 
+				// track subscriptions
+				s.track(cmd)
+
 				// bind future in a own goroutine. the goroutine will be
 				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*subscribeFuture).Future)
+				s.bind(cmd, f2.(*subscribeFuture).Future)
 			}
 
 			// handle unsubscribe command
@@ -436,9 +672,12 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 					return false
 				}
 
+				// track subscriptions
+				s.track(cmd)
+
 				// bind future in a own goroutine. the goroutine will be
 				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*future.Future))
+				s.bind(cmd, f2.(*future.Future))
 			}
 
 			// handle publish command
@@ -453,9 +692,15 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 					return false
 				}
 
-				// bind future in a own goroutine. the goroutine will be
-				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*future.Future))
+				// bind future
+				cmd.future.BindAsync(f2.(*future.Future))
+			}
+		case <-s.bufferSignal:
+			// hand over buffered messages
+			err := s.flush(client)
+			if err != nil {
+				s.err("Publish", err)
+				return false
 			}
 		case <-s.tomb.Dying():
 			// disconnect client on Stop
@@ -466,11 +711,301 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 
 			return true
 		case <-fail:
+			return false
+		case <-refresh:
+			s.log("Refresh Credentials")
+
+			// disconnect client before the credentials expire
+			err := client.Disconnect(s.DisconnectTimeout)
+			if err != nil {
+				s.err("Disconnect", err)
+			}
+
 			return false
 		}
 	}
 }
 
+// schedules the closing of the refresh channel before the credentials of the
+// client expire
+func (s *Service) schedule(client *Client, refresh chan struct{}) *time.Timer {
+	// check if enabled
+	if s.ReconnectBeforeExpiry <= 0 || client.credentials == nil || client.credentials.Expires.IsZero() {
+		return nil
+	}
+
+	// get delay and skip credentials that expire too soon to avoid a
+	// reconnect loop
+	d := time.Until(client.credentials.Expires) - s.ReconnectBeforeExpiry
+	if d <= 0 {
+		return nil
+	}
+
+	return time.AfterFunc(d, func() {
+		close(refresh)
+	})
+}
+
+// stores a message in the buffer and notifies the dispatcher
+func (s *Service) buffer(msg *packet.Message) *future.Future {
+	// allocate future
+	f := future.New()
+
+	s.bufferMutex.Lock()
+
+	// align futures with messages loaded by the buffer
+	s.alignBuffer()
+
+	// push message
+	err := s.Buffer.Push(msg)
+	if err == ErrBufferFull && s.DropPolicy == DropOldest && s.Buffer.Len() > 0 {
+		// drop oldest message
+		err = s.Buffer.Shift()
+		if err == nil {
+			if s.buffered[0] != nil {
+				s.buffered[0].Cancel()
+			}
+
+			s.buffered = s.buffered[1:]
+			err = s.Buffer.Push(msg)
+		}
+	}
+
+	// add future
+	if err == nil {
+		s.buffered = append(s.buffered, f)
+	}
+
+	s.bufferMutex.Unlock()
+
+	// cancel future on error
+	if err != nil {
+		s.err("Buffer", err)
+		f.Cancel()
+		return f
+	}
+
+	// notify dispatcher
+	select {
+	case s.bufferSignal <- struct{}{}:
+	default:
+	}
+
+	return f
+}
+
+// hands over all buffered messages to the client
+func (s *Service) flush(client *Client) error {
+	// check buffer
+	if s.Buffer == nil {
+		return nil
+	}
+
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+
+	// align futures with messages loaded by the buffer
+	s.alignBuffer()
+
+	for {
+		// get next message
+		msg, err := s.Buffer.Front()
+		if err != nil {
+			return err
+		} else if msg == nil {
+			return nil
+		}
+
+		// publish message
+		f2, err := client.PublishMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		// remove message
+		err = s.Buffer.Shift()
+		if err != nil {
+			return err
+		}
+
+		// get future
+		f := s.buffered[0]
+		s.buffered = s.buffered[1:]
+
+		// bind future
+		if f != nil {
+			f.BindAsync(f2.(*future.Future))
+		}
+	}
+}
+
+// prepends missing futures for messages that have been loaded by the buffer
+func (s *Service) alignBuffer() {
+	if n := s.Buffer.Len() - len(s.buffered); n > 0 {
+		s.buffered = append(make([]*future.Future, n), s.buffered...)
+	}
+}
+
+// updates the tracked subscriptions
+func (s *Service) track(cmd *command) {
+	// add or update subscriptions
+	for _, sub := range cmd.subscriptions {
+		found := false
+		for i, existing := range s.subscriptions {
+			if existing.Topic == sub.Topic {
+				s.subscriptions[i] = sub
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			s.subscriptions = append(s.subscriptions, sub)
+		}
+	}
+
+	// remove subscriptions
+	for _, topic := range cmd.topics {
+		for i, existing := range s.subscriptions {
+			if existing.Topic == topic {
+				s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// binds the future of a subscribe or unsubscribe command to the client future
+// and keeps track of it until it has been completed
+func (s *Service) bind(cmd *command, f *future.Future) {
+	// add pending future
+	s.pendingMutex.Lock()
+	s.pending[f] = cmd
+	s.pendingMutex.Unlock()
+
+	go func() {
+		// bind future
+		cmd.future.Bind(f)
+
+		// remove pending future
+		s.pendingMutex.Lock()
+		delete(s.pending, f)
+		s.pendingMutex.Unlock()
+	}()
+}
+
+// replays the tracked subscriptions and completes the futures of subscribe and
+// unsubscribe commands that have been lost with the previous session
+func (s *Service) restore(client *Client) error {
+	// get pending futures
+	s.pendingMutex.Lock()
+	pending := s.pending
+	s.pending = make(map[*future.Future]*command)
+	s.pendingMutex.Unlock()
+
+	// complete pending unsubscribes as the subscriptions are gone
+	subscribes := make(map[*future.Future]*command)
+	for f, cmd := range pending {
+		// remove future from store
+		s.futureStore.Remove(f)
+
+		if cmd.unsubscribe {
+			f.Complete()
+		} else {
+			subscribes[f] = cmd
+		}
+	}
+
+	// prepare result
+	result := future.New()
+
+	// copy subscriptions
+	subs := make([]packet.Subscription, len(s.subscriptions))
+	copy(subs, s.subscriptions)
+
+	// replay subscriptions
+	if len(subs) > 0 {
+		s.log(fmt.Sprintf("Restore Subscriptions: %d", len(subs)))
+
+		// subscribe
+		rf, err := client.SubscribeMultiple(subs)
+		if err != nil {
+			// keep pending subscribes for the next attempt
+			s.pendingMutex.Lock()
+			for f, cmd := range subscribes {
+				s.pending[f] = cmd
+			}
+			s.pendingMutex.Unlock()
+
+			return err
+		}
+
+		// bind replay, which is itself completed if it gets lost
+		s.bind(&command{
+			subscribe:     true,
+			future:        result,
+			subscriptions: subs,
+		}, rf.(*subscribeFuture).Future)
+	} else {
+		result.Complete()
+	}
+
+	// return if there is nothing to complete
+	if len(subscribes) == 0 {
+		return nil
+	}
+
+	// complete pending subscribes once the replay has been completed or
+	// canceled, the replay is canceled at the latest when the service stops
+	result.OnComplete(func(err error) {
+		// cancel pending subscribes if the replay has been canceled
+		if err != nil {
+			for f := range subscribes {
+				f.Cancel()
+			}
+
+			return
+		}
+
+		// get return codes
+		codes := make(map[string]uint8)
+		if v, ok := result.Data.Load(returnCodesKey); ok {
+			for i, code := range v.([]uint8) {
+				if i < len(subs) {
+					codes[subs[i].Topic] = code
+				}
+			}
+		}
+
+		// complete pending subscribes
+		for f, cmd := range subscribes {
+			returnCodes := make([]uint8, len(cmd.subscriptions))
+			for i, sub := range cmd.subscriptions {
+				code, ok := codes[sub.Topic]
+				if !ok {
+					code = packet.QOSFailure
+				}
+
+				returnCodes[i] = code
+			}
+
+			f.Data.Store(returnCodesKey, returnCodes)
+			f.Complete()
+		}
+	})
+
+	return nil
+}
+
+// calls the message callback
+func (s *Service) callback(msg *packet.Message) error {
+	if s.MessageCallback != nil {
+		return s.MessageCallback(msg)
+	}
+
+	return nil
+}
+
 func (s *Service) err(sys string, err error) {
 	s.log(fmt.Sprintf("%s Error: %s", sys, err.Error()))
 
@@ -484,3 +1019,13 @@ func (s *Service) log(str string) {
 		s.Logger(str)
 	}
 }
+
+// returns whether the channel has been closed
+func closed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,10 +1,13 @@
 package client
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/256dpi/gomqtt/client/future"
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport"
 	"github.com/256dpi/gomqtt/transport/flow"
 	"github.com/stretchr/testify/assert"
 )
@@ -316,6 +319,70 @@ func TestServiceFutureSurvival(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestServiceResubscribe(t *testing.T) {
+	subscribe1 := packet.NewSubscribePacket()
+	subscribe1.Subscriptions = []packet.Subscription{{Topic: "test"}}
+	subscribe1.ID = 1
+
+	suback1 := packet.NewSubackPacket()
+	suback1.ReturnCodes = []uint8{0}
+	suback1.ID = 1
+
+	subscribe2 := packet.NewSubscribePacket()
+	subscribe2.Subscriptions = []packet.Subscription{{Topic: "pending", QOS: 1}}
+	subscribe2.ID = 2
+
+	unsubscribe := packet.NewUnsubscribePacket()
+	unsubscribe.Topics = []string{"test"}
+	unsubscribe.ID = 3
+
+	subscribe3 := packet.NewSubscribePacket()
+	subscribe3.Subscriptions = []packet.Subscription{{Topic: "pending", QOS: 1}}
+	subscribe3.ID = 1
+
+	suback3 := packet.NewSubackPacket()
+	suback3.ReturnCodes = []uint8{1}
+	suback3.ID = 1
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe1).
+		Send(suback1).
+		Receive(subscribe2).
+		Receive(unsubscribe).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe3).
+		Delay(1500 * time.Millisecond).
+		Send(suback3).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	s := NewService()
+	s.MinReconnectDelay = 50 * time.Millisecond
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.NoError(t, s.Subscribe("test", 0).Wait(time.Second))
+
+	sf := s.Subscribe("pending", 1)
+	uf := s.Unsubscribe("test")
+
+	assert.NoError(t, sf.Wait(5*time.Second))
+	assert.Equal(t, []uint8{1}, sf.ReturnCodes())
+	assert.NoError(t, uf.Wait(5*time.Second))
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
 func BenchmarkServicePublish(b *testing.B) {
 	ready := make(chan struct{})
 	done := make(chan struct{})
@@ -342,3 +409,422 @@ func BenchmarkServicePublish(b *testing.B) {
 
 	safeReceive(done)
 }
+
+func TestServiceBuffer(t *testing.T) {
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("2")
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message.Topic = "test"
+	publish2.Message.Payload = []byte("3")
+
+	publish3 := packet.NewPublishPacket()
+	publish3.Message.Topic = "test"
+	publish3.Message.Payload = []byte("4")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish1).
+		Receive(publish2).
+		Receive(publish3).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	s.Buffer = NewMemoryBuffer(2)
+	s.DropPolicy = DropOldest
+
+	errs := make(chan error, 1)
+	s.ErrorCallback = func(err error) {
+		errs <- err
+	}
+
+	f1 := s.Publish("test", []byte("1"), 0, false)
+	f2 := s.Publish("test", []byte("2"), 0, false)
+	f3 := s.Publish("test", []byte("3"), 0, false)
+	assert.Equal(t, 2, s.QueueDepth())
+
+	s.DropPolicy = DropNewest
+	f4 := s.Publish("test", []byte("x"), 0, false)
+	assert.Equal(t, ErrBufferFull, <-errs)
+
+	assert.Equal(t, future.ErrCanceled, f1.Wait(10*time.Millisecond))
+	assert.Equal(t, future.ErrCanceled, f4.Wait(10*time.Millisecond))
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.NoError(t, f2.Wait(1*time.Second))
+	assert.NoError(t, f3.Wait(1*time.Second))
+	assert.NoError(t, s.Publish("test", []byte("4"), 0, false).Wait(1*time.Second))
+	assert.Equal(t, 0, s.QueueDepth())
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestServiceBufferStop(t *testing.T) {
+	server, err := transport.Launch("tcp://localhost:0")
+	assert.NoError(t, err)
+	unavailable := "tcp://" + server.Addr().String()
+	assert.NoError(t, server.Close())
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("1")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	s.Buffer = NewMemoryBuffer(0)
+
+	s.Start(NewConfig(unavailable))
+
+	f := s.Publish("test", []byte("1"), 0, false)
+	assert.Equal(t, 1, s.QueueDepth())
+
+	s.Stop(true)
+
+	assert.Equal(t, future.ErrTimeout, f.Wait(10*time.Millisecond))
+	assert.Equal(t, 1, s.QueueDepth())
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.NoError(t, f.Wait(1*time.Second))
+	assert.Equal(t, 0, s.QueueDepth())
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestServiceFailover(t *testing.T) {
+	server, err := transport.Launch("tcp://localhost:0")
+	assert.NoError(t, err)
+	unavailable := "tcp://" + server.Addr().String()
+	assert.NoError(t, server.Close())
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	online := make(chan string, 1)
+
+	s := NewService()
+
+	s.OnlineCallback = func(resumed bool) {
+		online <- s.BrokerURL()
+	}
+
+	s.Start(NewConfigWithBrokers(Failover, unavailable, "tcp://localhost:"+port))
+
+	select {
+	case url := <-online:
+		assert.Equal(t, "tcp://localhost:"+port, url)
+	case <-time.After(time.Second):
+		assert.Fail(t, "service not online")
+	}
+
+	s.Stop(true)
+
+	assert.Equal(t, "", s.BrokerURL())
+
+	safeReceive(done)
+}
+
+func TestServiceWorkers(t *testing.T) {
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "a"
+	publish1.Message.Payload = []byte("1")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message.Topic = "b"
+	publish2.Message.Payload = []byte("2")
+	publish2.Message.QOS = 1
+	publish2.ID = 2
+
+	publish3 := packet.NewPublishPacket()
+	publish3.Message.Topic = "a"
+	publish3.Message.Payload = []byte("3")
+	publish3.Message.QOS = 1
+	publish3.ID = 3
+
+	puback1 := packet.NewPubackPacket()
+	puback1.ID = 1
+
+	puback2 := packet.NewPubackPacket()
+	puback2.ID = 2
+
+	puback3 := packet.NewPubackPacket()
+	puback3.ID = 3
+
+	acked := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish1).
+		Send(publish2).
+		Send(publish3).
+		Receive(puback1).
+		Receive(puback2).
+		Receive(puback3).
+		Run(func() {
+			close(acked)
+		}).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	s.Workers = 2
+
+	// topic "a" and "b" are handled by different workers
+	handled := make(chan string, 3)
+	release := make(chan struct{})
+
+	s.MessageCallback = func(msg *packet.Message) error {
+		if string(msg.Payload) == "1" {
+			<-release
+		} else if string(msg.Payload) == "2" {
+			close(release)
+		}
+
+		handled <- string(msg.Payload)
+		return nil
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(acked)
+
+	assert.Equal(t, "2", <-handled)
+	assert.Equal(t, "1", <-handled)
+	assert.Equal(t, "3", <-handled)
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestServiceDeliveryReports(t *testing.T) {
+	connect := connectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+
+	connack := connackPacket()
+	connack.SessionPresent = true
+
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message.Topic = "test"
+	publish2.Message.Payload = []byte("test")
+	publish2.Message.QOS = 1
+	publish2.Dup = true
+	publish2.ID = 1
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 1
+
+	broker1 := flow.New().
+		Receive(connect).
+		Send(connack).
+		Receive(publish1).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connack).
+		Receive(publish2).
+		Send(puback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	config := NewConfigWithClientID("tcp://localhost:"+port, "test")
+	config.CleanSession = false
+
+	reports := make(chan *DeliveryReport, 10)
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+	s.DeliveryReports = reports
+
+	s.Start(config)
+
+	f := s.Publish("test", []byte("test"), 1, false)
+
+	var result error
+	completed := make(chan struct{})
+	f.OnComplete(func(err error) {
+		result = err
+		close(completed)
+	})
+
+	safeReceive(completed)
+	assert.NoError(t, result)
+
+	report := <-reports
+	assert.Equal(t, "test", report.Message.Topic)
+	assert.NoError(t, report.Err)
+	assert.True(t, report.Latency > 0)
+	assert.Equal(t, 1, report.Retransmissions)
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestServiceStats(t *testing.T) {
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online := make(chan struct{}, 2)
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+
+	s.OnlineCallback = func(resumed bool) {
+		online <- struct{}{}
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online)
+	safeReceive(online)
+
+	s.Stop(true)
+
+	safeReceive(done)
+
+	stats := s.Stats()
+	assert.Equal(t, int64(1), stats.Reconnects)
+	assert.Equal(t, map[string]int64{"EOF": 1}, stats.ReconnectReasons)
+	assert.Equal(t, int64(2*connectPacket().Len()+2), stats.BytesSent)
+	assert.Equal(t, int64(2*connackPacket().Len()), stats.BytesReceived)
+}
+
+func TestServiceCircuitBreaker(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.ErrNotAuthorized
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		End()
+
+	done, port := fakeBroker(t, broker, broker)
+
+	errs := make(chan error, 10)
+
+	s := NewService()
+	s.ReconnectStrategy = &CircuitBreaker{
+		Strategy:  &ExponentialBackoff{Min: 10 * time.Millisecond, Max: 10 * time.Millisecond},
+		Threshold: 2,
+	}
+
+	s.ErrorCallback = func(err error) {
+		errs <- err
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(done)
+
+	assert.Equal(t, ErrClientConnectionDenied, <-errs)
+	assert.Equal(t, packet.ErrNotAuthorized, <-errs)
+	assert.Equal(t, ErrClientConnectionDenied, <-errs)
+	assert.Equal(t, packet.ErrNotAuthorized, <-errs)
+	assert.Equal(t, &CircuitOpenError{
+		Failures: 2,
+		Err:      packet.ErrNotAuthorized,
+	}, <-errs)
+
+	s.Stop(true)
+}
+
+func TestServiceReconnectBeforeExpiry(t *testing.T) {
+	connect1 := connectPacket()
+	connect1.Username = "token1"
+
+	connect2 := connectPacket()
+	connect2.Username = "token2"
+
+	broker1 := flow.New().
+		Receive(connect1).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	broker2 := flow.New().
+		Receive(connect2).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online := make(chan struct{}, 2)
+
+	s := NewService()
+	s.ReconnectBeforeExpiry = 50 * time.Millisecond
+
+	s.OnlineCallback = func(resumed bool) {
+		online <- struct{}{}
+	}
+
+	var i int
+	config := NewConfig("tcp://localhost:" + port)
+	config.CredentialsProvider = func(url string) (*Credentials, error) {
+		i++
+		return &Credentials{
+			Username: "token" + strconv.Itoa(i),
+			Expires:  time.Now().Add(100 * time.Millisecond),
+		}, nil
+	}
+
+	s.Start(config)
+
+	safeReceive(online)
+	safeReceive(online)
+
+	s.Stop(true)
+
+	safeReceive(done)
+
+	assert.Equal(t, int64(0), s.Stats().Reconnects)
+}
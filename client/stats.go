@@ -0,0 +1,140 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyStats describes the acknowledgement latencies of published messages.
+type LatencyStats struct {
+	// The number of measured messages.
+	Count int64
+
+	// The latency of the last measured message.
+	Last time.Duration
+
+	// The average latency of all measured messages.
+	Average time.Duration
+
+	// The highest latency of all measured messages.
+	Max time.Duration
+}
+
+// Stats is a snapshot of the keep alive and connection quality statistics of a
+// Client or Service.
+type Stats struct {
+	// The number of sent pings and received pongs.
+	Pings int64
+	Pongs int64
+
+	// The round trip time of the last answered ping.
+	PingRTT time.Duration
+
+	// The acknowledgement latencies of published messages indexed by their
+	// QOS level. QOS 0 messages are measured until they have been written.
+	PublishLatency [3]LatencyStats
+
+	// The number of reconnects triggered by failed connection attempts and
+	// lost connections and their count by error message.
+	Reconnects       int64
+	ReconnectReasons map[string]int64
+
+	// The number of bytes sent and received.
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// a statistics collects the measurements of one or more clients
+type statistics struct {
+	sync.Mutex
+
+	stats Stats
+}
+
+// returns a new statistics
+func newStatistics() *statistics {
+	return &statistics{
+		stats: Stats{
+			ReconnectReasons: make(map[string]int64),
+		},
+	}
+}
+
+// records a sent ping
+func (s *statistics) ping() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.Pings++
+}
+
+// records a received pong
+func (s *statistics) pong(rtt time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.Pongs++
+	s.stats.PingRTT = rtt
+}
+
+// records the acknowledgement latency of a published message
+func (s *statistics) publish(qos uint8, latency time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	// check qos
+	if qos > 2 {
+		return
+	}
+
+	// update latency
+	l := &s.stats.PublishLatency[qos]
+	l.Average = (l.Average*time.Duration(l.Count) + latency) / time.Duration(l.Count+1)
+	l.Count++
+	l.Last = latency
+	if latency > l.Max {
+		l.Max = latency
+	}
+}
+
+// records a reconnect and its reason
+func (s *statistics) reconnect(reason error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.Reconnects++
+	if reason != nil {
+		s.stats.ReconnectReasons[reason.Error()]++
+	}
+}
+
+// records sent bytes
+func (s *statistics) sent(n int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.BytesSent += int64(n)
+}
+
+// records received bytes
+func (s *statistics) received(n int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.BytesReceived += int64(n)
+}
+
+// returns a copy of the current statistics
+func (s *statistics) snapshot() Stats {
+	s.Lock()
+	defer s.Unlock()
+
+	// copy stats
+	stats := s.stats
+	stats.ReconnectReasons = make(map[string]int64, len(s.stats.ReconnectReasons))
+	for reason, count := range s.stats.ReconnectReasons {
+		stats.ReconnectReasons[reason] = count
+	}
+
+	return stats
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatistics(t *testing.T) {
+	s := newStatistics()
+
+	s.ping()
+	s.pong(5 * time.Millisecond)
+
+	s.publish(1, 10*time.Millisecond)
+	s.publish(1, 30*time.Millisecond)
+	s.publish(2, 40*time.Millisecond)
+	s.publish(3, time.Second)
+
+	s.reconnect(errors.New("foo"))
+	s.reconnect(errors.New("foo"))
+	s.reconnect(nil)
+
+	s.sent(10)
+	s.received(20)
+
+	stats := s.snapshot()
+	assert.Equal(t, Stats{
+		Pings:   1,
+		Pongs:   1,
+		PingRTT: 5 * time.Millisecond,
+		PublishLatency: [3]LatencyStats{
+			{},
+			{Count: 2, Last: 30 * time.Millisecond, Average: 20 * time.Millisecond, Max: 30 * time.Millisecond},
+			{Count: 1, Last: 40 * time.Millisecond, Average: 40 * time.Millisecond, Max: 40 * time.Millisecond},
+		},
+		Reconnects:       3,
+		ReconnectReasons: map[string]int64{"foo": 2},
+		BytesSent:        10,
+		BytesReceived:    20,
+	}, stats)
+
+	stats.ReconnectReasons["bar"] = 1
+	assert.Len(t, s.snapshot().ReconnectReasons, 1)
+}
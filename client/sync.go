@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// ErrSubscriptionClosed is returned by Next if the subscription has been
+// closed or the SyncClient has been disconnected.
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// A SyncClient wraps a Client and provides blocking methods that are
+// convenient in scripts and tests. In contrast to the helpers in tools.go it
+// reuses a single connection for all calls.
+//
+// Note: Received messages are buffered per subscription. If the buffer of a
+// matching subscription is full, the processing of incoming packets is blocked
+// until the message has been consumed using Next or the subscription is closed.
+type SyncClient struct {
+	// The underlying client.
+	Client *Client
+
+	// The number of messages buffered per subscription.
+	Buffer int
+
+	tree  *topic.Tree
+	subs  map[*Subscription]bool
+	mutex sync.Mutex
+}
+
+// NewSyncClient will create and return a new SyncClient.
+func NewSyncClient() *SyncClient {
+	c := &SyncClient{
+		Client: New(),
+		Buffer: 100,
+		tree:   topic.NewTree(),
+		subs:   make(map[*Subscription]bool),
+	}
+
+	// set callback
+	c.Client.Callback = c.callback
+
+	return c
+}
+
+// Connect will connect to the broker and block until the connection has been
+// acknowledged or the context is done. It will return whether a session was
+// present.
+func (c *SyncClient) Connect(ctx context.Context, config *Config) (bool, error) {
+	connectFuture, err := c.Client.ConnectContext(ctx, config)
+	if err != nil {
+		return false, err
+	}
+
+	return connectFuture.SessionPresent(), nil
+}
+
+// Publish will publish the message and block until the quality of service flow
+// has been completed or the context is done.
+func (c *SyncClient) Publish(ctx context.Context, msg *packet.Message) error {
+	return c.Client.PublishMessageContext(ctx, msg)
+}
+
+// Subscribe will subscribe to the specified filter and block until the
+// subscription has been acknowledged or the context is done. The returned
+// subscription receives all messages that match the filter.
+func (c *SyncClient) Subscribe(ctx context.Context, filter string, qos uint8) (*Subscription, error) {
+	// prepare subscription
+	sub := &Subscription{
+		Filter:   filter,
+		QOS:      qos,
+		client:   c,
+		messages: make(chan *packet.Message, c.Buffer),
+		done:     make(chan struct{}),
+	}
+
+	// add subscription before subscribing to not miss any messages
+	c.mutex.Lock()
+	c.tree.Add(filter, sub)
+	c.subs[sub] = true
+	c.mutex.Unlock()
+
+	// subscribe filter
+	subscribeFuture, err := c.Client.SubscribeContext(ctx, filter, qos)
+	if err != nil {
+		c.remove(sub, err)
+		return nil, err
+	}
+
+	// check return code
+	if codes := subscribeFuture.ReturnCodes(); len(codes) > 0 && codes[0] == packet.QOSFailure {
+		c.remove(sub, ErrFailedSubscription)
+		return nil, ErrFailedSubscription
+	}
+
+	return sub, nil
+}
+
+// Collect will subscribe to the specified filter with QOS 1 and block until n
+// messages have been received or the timeout is reached. The filter is
+// unsubscribed afterwards. If less than n messages have been received, the
+// received messages are returned together with future.ErrTimeout.
+func (c *SyncClient) Collect(filter string, n int, timeout time.Duration) ([]*packet.Message, error) {
+	// prepare context
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// subscribe filter
+	sub, err := c.Subscribe(ctx, filter, 1)
+	if err == context.DeadlineExceeded {
+		return nil, future.ErrTimeout
+	} else if err != nil {
+		return nil, err
+	}
+
+	// collect messages
+	var msgs []*packet.Message
+	for len(msgs) < n {
+		var msg *packet.Message
+		msg, err = sub.Next(ctx)
+		if err == context.DeadlineExceeded {
+			err = future.ErrTimeout
+			break
+		} else if err != nil {
+			break
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	// unsubscribe filter
+	unsubscribeCtx, unsubscribeCancel := context.WithTimeout(context.Background(), timeout)
+	defer unsubscribeCancel()
+	unsubscribeErr := sub.Unsubscribe(unsubscribeCtx)
+	if err == nil {
+		err = unsubscribeErr
+	}
+
+	return msgs, err
+}
+
+// Disconnect will disconnect from the broker and close all subscriptions.
+func (c *SyncClient) Disconnect(timeout ...time.Duration) error {
+	err := c.Client.Disconnect(timeout...)
+	c.closeAll(ErrSubscriptionClosed)
+	return err
+}
+
+func (c *SyncClient) callback(msg *packet.Message, err error) error {
+	// close all subscriptions on error
+	if err != nil {
+		c.closeAll(err)
+		return nil
+	}
+
+	// get matching subscriptions
+	c.mutex.Lock()
+	values := c.tree.Match(msg.Topic)
+	c.mutex.Unlock()
+
+	// queue message
+	for _, value := range values {
+		sub := value.(*Subscription)
+		select {
+		case sub.messages <- msg:
+		case <-sub.done:
+		}
+	}
+
+	return nil
+}
+
+// removes the subscription and closes it with the specified error
+func (c *SyncClient) remove(sub *Subscription, err error) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// check subscription
+	if !c.subs[sub] {
+		return false
+	}
+
+	// remove subscription
+	c.tree.Remove(sub.Filter, sub)
+	delete(c.subs, sub)
+
+	// close subscription
+	sub.err = err
+	close(sub.done)
+
+	return true
+}
+
+// removes and closes all subscriptions
+func (c *SyncClient) closeAll(err error) {
+	c.mutex.Lock()
+	var subs []*Subscription
+	for sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	c.mutex.Unlock()
+
+	for _, sub := range subs {
+		c.remove(sub, err)
+	}
+}
+
+// returns whether another subscription uses the same filter
+func (c *SyncClient) shared(filter string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.tree.Get(filter)) > 0
+}
+
+// A Subscription is returned by SyncClient.Subscribe and buffers the messages
+// that match its filter.
+type Subscription struct {
+	// The subscribed filter.
+	Filter string
+
+	// The requested QOS level.
+	QOS uint8
+
+	client   *SyncClient
+	messages chan *packet.Message
+	done     chan struct{}
+	err      error
+}
+
+// Next will block until the next message has been received or the context is
+// done. Buffered messages are still returned after the subscription has been
+// closed. Afterwards, ErrSubscriptionClosed or the error that caused the
+// connection to be closed is returned.
+func (s *Subscription) Next(ctx context.Context) (*packet.Message, error) {
+	// return buffered message
+	select {
+	case msg := <-s.messages:
+		return msg, nil
+	default:
+	}
+
+	// wait for message
+	select {
+	case msg := <-s.messages:
+		return msg, nil
+	case <-s.done:
+		// check buffer again as the subscription might have been closed while
+		// the message was queued
+		select {
+		case msg := <-s.messages:
+			return msg, nil
+		default:
+			return nil, s.err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Unsubscribe will close the subscription and unsubscribe from the filter
+// unless another subscription uses the same filter. It will block until the
+// unsubscription has been acknowledged or the context is done.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	// close subscription
+	if !s.client.remove(s, ErrSubscriptionClosed) {
+		return nil
+	}
+
+	// check other subscriptions
+	if s.client.shared(s.Filter) {
+		return nil
+	}
+
+	return s.client.Client.UnsubscribeContext(ctx, s.Filter)
+}
+
+// Close will close the subscription without unsubscribing from the filter.
+func (s *Subscription) Close() {
+	s.client.remove(s, ErrSubscriptionClosed)
+}
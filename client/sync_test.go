@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncClient(t *testing.T) {
+	publish := packet.NewPublishPacket()
+	publish.Message = packet.Message{
+		Topic:   "test",
+		Payload: []byte("test"),
+		QOS:     1,
+	}
+	publish.ID = 1
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 1
+
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = 2
+	subscribe.Subscriptions = []packet.Subscription{
+		{Topic: "foo/#", QOS: 1},
+	}
+
+	suback := packet.NewSubackPacket()
+	suback.ID = 2
+	suback.ReturnCodes = []uint8{1}
+
+	publish1 := packet.NewPublishPacket()
+	publish1.Message = packet.Message{
+		Topic:   "foo/bar",
+		Payload: []byte("1"),
+	}
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message = packet.Message{
+		Topic:   "foo/baz",
+		Payload: []byte("2"),
+	}
+
+	unsubscribe := packet.NewUnsubscribePacket()
+	unsubscribe.ID = 3
+	unsubscribe.Topics = []string{"foo/#"}
+
+	unsuback := packet.NewUnsubackPacket()
+	unsuback.ID = 3
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish).
+		Send(puback).
+		Receive(subscribe).
+		Send(suback).
+		Send(publish1).
+		Send(publish2).
+		Receive(unsubscribe).
+		Send(unsuback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := NewSyncClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sessionPresent, err := c.Connect(ctx, NewConfig("tcp://localhost:"+port))
+	assert.NoError(t, err)
+	assert.False(t, sessionPresent)
+
+	err = c.Publish(ctx, &publish.Message)
+	assert.NoError(t, err)
+
+	msgs, err := c.Collect("foo/#", 2, time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, publish1.Message.String(), msgs[0].String())
+	assert.Equal(t, publish2.Message.String(), msgs[1].String())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestSyncClientCollectTimeout(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = 1
+	subscribe.Subscriptions = []packet.Subscription{
+		{Topic: "test", QOS: 1},
+	}
+
+	suback := packet.NewSubackPacket()
+	suback.ID = 1
+	suback.ReturnCodes = []uint8{1}
+
+	publish := packet.NewPublishPacket()
+	publish.Message = packet.Message{
+		Topic:   "test",
+		Payload: []byte("test"),
+	}
+
+	unsubscribe := packet.NewUnsubscribePacket()
+	unsubscribe.ID = 2
+	unsubscribe.Topics = []string{"test"}
+
+	unsuback := packet.NewUnsubackPacket()
+	unsuback.ID = 2
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Send(publish).
+		Receive(unsubscribe).
+		Send(unsuback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := NewSyncClient()
+
+	_, err := c.Connect(context.Background(), NewConfig("tcp://localhost:"+port))
+	assert.NoError(t, err)
+
+	msgs, err := c.Collect("test", 2, 100*time.Millisecond)
+	assert.Equal(t, future.ErrTimeout, err)
+	assert.Len(t, msgs, 1)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestSyncClientSubscriptionClosed(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = 1
+	subscribe.Subscriptions = []packet.Subscription{
+		{Topic: "test"},
+	}
+
+	suback := packet.NewSubackPacket()
+	suback.ID = 1
+	suback.ReturnCodes = []uint8{0}
+
+	publish := packet.NewPublishPacket()
+	publish.Message = packet.Message{
+		Topic:   "test",
+		Payload: []byte("test"),
+	}
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Send(publish).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	c := NewSyncClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := c.Connect(ctx, NewConfig("tcp://localhost:"+port))
+	assert.NoError(t, err)
+
+	sub, err := c.Subscribe(ctx, "test", 0)
+	assert.NoError(t, err)
+
+	msg, err := sub.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, publish.Message.String(), msg.String())
+
+	msg, err = sub.Next(ctx)
+	assert.Error(t, err)
+	assert.NotEqual(t, context.DeadlineExceeded, err)
+	assert.Nil(t, msg)
+
+	safeReceive(done)
+}
+
+func TestSyncClientFailedSubscription(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = 1
+	subscribe.Subscriptions = []packet.Subscription{
+		{Topic: "test"},
+	}
+
+	suback := packet.NewSubackPacket()
+	suback.ID = 1
+	suback.ReturnCodes = []uint8{packet.QOSFailure}
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := NewSyncClient()
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ValidateSubs = false
+
+	_, err := c.Connect(context.Background(), config)
+	assert.NoError(t, err)
+
+	sub, err := c.Subscribe(context.Background(), "test", 0)
+	assert.Equal(t, ErrFailedSubscription, err)
+	assert.Nil(t, sub)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
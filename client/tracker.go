@@ -12,6 +12,8 @@ type tracker struct {
 	last    time.Time
 	pings   uint8
 	timeout time.Duration
+	sent    time.Time
+	rtt     time.Duration
 }
 
 // returns a new tracker
@@ -44,6 +46,7 @@ func (t *tracker) ping() {
 	defer t.Unlock()
 
 	t.pings++
+	t.sent = time.Now()
 }
 
 // mark pong
@@ -52,6 +55,15 @@ func (t *tracker) pong() {
 	defer t.Unlock()
 
 	t.pings--
+	t.rtt = time.Since(t.sent)
+}
+
+// returns the round trip time of the last answered ping
+func (t *tracker) roundTrip() time.Duration {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.rtt
 }
 
 // returns if pings are pending
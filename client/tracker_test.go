@@ -21,6 +21,9 @@ func TestTracker(t *testing.T) {
 	tracker.ping()
 	assert.True(t, tracker.pending())
 
+	time.Sleep(time.Millisecond)
+
 	tracker.pong()
 	assert.False(t, tracker.pending())
+	assert.True(t, tracker.roundTrip() >= time.Millisecond)
 }
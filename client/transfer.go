@@ -0,0 +1,450 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrChecksumMismatch is returned by Send and emitted by the Receiver if the
+// reassembled data does not match the checksum of the manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrTransferIncomplete is returned by Send and emitted by the Receiver if
+// chunks are still missing after all retransmission requests have been made.
+var ErrTransferIncomplete = errors.New("transfer incomplete")
+
+// ErrInvalidManifest is returned by Send and emitted by the Receiver if the
+// manifest is malformed or exceeds the limits of the receiver.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// ErrTooManyTransfers is returned by Send and emitted by the Receiver if the
+// receiver already handles the maximum number of concurrent transfers.
+var ErrTooManyTransfers = errors.New("too many transfers")
+
+// A Manifest describes the data sent by a chunked transfer.
+type Manifest struct {
+	ID       string `json:"id"`
+	Size     int64  `json:"size"`
+	Chunks   int    `json:"chunks"`
+	Checksum string `json:"sha256"`
+}
+
+// the status published by the receiver of a transfer
+type transferStatus struct {
+	Missing  []int  `json:"missing,omitempty"`
+	Complete bool   `json:"complete,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// A Sender splits data into chunks that are published with QOS 1 to
+// "<topic>/<id>/<index>" followed by a manifest published to
+// "<topic>/<id>/manifest". It then waits for the receiver to report the
+// completion or request the retransmission of missing chunks on
+// "<topic>/<id>/status".
+type Sender struct {
+	router *Router
+	topic  string
+
+	// The maximum size of a single chunk.
+	ChunkSize int
+}
+
+// NewSender will create and return a new Sender that publishes transfers below
+// the specified topic using the router.
+func NewSender(router *Router, topic string) *Sender {
+	return &Sender{
+		router:    router,
+		topic:     topic,
+		ChunkSize: 32 * 1024,
+	}
+}
+
+// Send will read the data from the reader and transfer it to the receiver. It
+// will block until the receiver reported the completion or the context is
+// done. The chunks are kept in memory to allow retransmissions.
+func (s *Sender) Send(ctx context.Context, r io.Reader) (*Manifest, error) {
+	// prepare manifest
+	manifest := &Manifest{
+		ID: randomID(),
+	}
+
+	// get base topic
+	base := s.topic + "/" + manifest.ID
+
+	// subscribe status, retransmission requests may be dropped as they are
+	// repeated by the receiver while the first final status is always kept
+	statuses := make(chan *transferStatus, 10)
+	final := make(chan *transferStatus, 1)
+	subscribeFuture := s.router.Handle(base+"/status", 1, func(msg *packet.Message, params Params) error {
+		var status transferStatus
+		if json.Unmarshal(msg.Payload, &status) != nil {
+			return nil
+		}
+
+		// queue status
+		queue := statuses
+		if status.Complete || status.Error != "" {
+			queue = final
+		}
+		select {
+		case queue <- &status:
+		default:
+		}
+
+		return nil
+	})
+
+	// ensure status is unsubscribed
+	defer s.router.Remove(base + "/status")
+
+	// wait for subscription
+	err := subscribeFuture.WaitContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// read and publish chunks
+	var chunks [][]byte
+	hash := sha256.New()
+	for {
+		// read chunk
+		buf := make([]byte, s.ChunkSize)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		// check end
+		if n == 0 {
+			break
+		}
+
+		// add chunk
+		chunk := buf[:n]
+		chunks = append(chunks, chunk)
+		hash.Write(chunk)
+		manifest.Size += int64(n)
+
+		// publish chunk
+		s.router.service.Publish(base+"/"+strconv.Itoa(len(chunks)-1), chunk, 1, false)
+
+		// check end
+		if n < s.ChunkSize {
+			break
+		}
+	}
+
+	// finish manifest
+	manifest.Chunks = len(chunks)
+	manifest.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	// publish manifest
+	payload, _ := json.Marshal(manifest)
+	s.router.service.Publish(base+"/manifest", payload, 1, false)
+
+	for {
+		select {
+		case status := <-final:
+			// check result
+			if status.Complete {
+				return manifest, nil
+			}
+
+			// return known errors
+			for _, err := range []error{ErrChecksumMismatch, ErrInvalidManifest, ErrTooManyTransfers, ErrTransferIncomplete} {
+				if status.Error == err.Error() {
+					return manifest, err
+				}
+			}
+
+			return manifest, errors.New(status.Error)
+		case status := <-statuses:
+			// retransmit missing chunks
+			for _, index := range status.Missing {
+				if index >= 0 && index < len(chunks) {
+					s.router.service.Publish(base+"/"+strconv.Itoa(index), chunks[index], 1, false)
+				}
+			}
+		case <-ctx.Done():
+			return manifest, ctx.Err()
+		}
+	}
+}
+
+// A TransferCallback is called by the Receiver with the manifest and the
+// reassembled data of a completed transfer. A returned error is reported to
+// the sender.
+type TransferCallback func(manifest *Manifest, data []byte) error
+
+// the state of a transfer in progress
+type transfer struct {
+	id          string
+	manifest    *Manifest
+	chunks      map[int][]byte
+	size        int64
+	retransmits int
+	timer       *time.Timer
+}
+
+// A Receiver reassembles the chunks published by a Sender. If the transfer is
+// not completed within the RetransmitTimeout after the last received chunk or
+// manifest, the missing chunks are requested again. Transfers are abandoned
+// after MaxRetransmits requests and an ErrTransferIncomplete is emitted using
+// the ErrorCallback of the service.
+//
+// Chunks are buffered in memory until a transfer is completed. The memory used
+// is therefore limited by MaxTransfers, MaxSize, MaxChunks and MaxChunkSize.
+// Manifests that exceed these limits are rejected with an ErrInvalidManifest.
+type Receiver struct {
+	router    *Router
+	topic     string
+	callback  TransferCallback
+	transfers map[string]*transfer
+	mutex     sync.Mutex
+
+	// The time to wait for missing chunks before requesting them again.
+	RetransmitTimeout time.Duration
+
+	// The maximum number of retransmission requests per transfer.
+	MaxRetransmits int
+
+	// The maximum size of the data of a single transfer.
+	MaxSize int64
+
+	// The maximum size of a single chunk. It must not be smaller than the
+	// ChunkSize of the sender.
+	MaxChunkSize int
+
+	// The maximum number of chunks of a single transfer.
+	MaxChunks int
+
+	// The maximum number of concurrent transfers.
+	MaxTransfers int
+}
+
+// NewReceiver will create and return a new Receiver that handles transfers
+// below the specified topic using the router. It will also return the
+// SubscribeFuture of the underlying subscription.
+func NewReceiver(router *Router, topic string, callback TransferCallback) (*Receiver, SubscribeFuture) {
+	r := &Receiver{
+		router:            router,
+		topic:             topic,
+		callback:          callback,
+		transfers:         make(map[string]*transfer),
+		RetransmitTimeout: 5 * time.Second,
+		MaxRetransmits:    3,
+		MaxSize:           64 * 1024 * 1024,
+		MaxChunkSize:      1024 * 1024,
+		MaxChunks:         64 * 1024,
+		MaxTransfers:      16,
+	}
+
+	// subscribe transfers
+	subscribeFuture := router.Handle(topic+"/{id}/{part}", 1, r.handle)
+
+	return r, subscribeFuture
+}
+
+func (r *Receiver) handle(msg *packet.Message, params Params) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := params["id"]
+	part := params["part"]
+
+	// ignore status messages
+	if part == "status" {
+		return nil
+	}
+
+	// get transfer
+	t, ok := r.transfers[id]
+	if !ok {
+		t = &transfer{
+			id:     id,
+			chunks: make(map[int][]byte),
+		}
+
+		// check transfers, the sender is only notified when the manifest
+		// arrives as chunks are published before
+		if len(r.transfers) >= r.MaxTransfers {
+			if part == "manifest" {
+				r.status(t, &transferStatus{Error: ErrTooManyTransfers.Error()})
+				r.router.service.err("Transfer", ErrTooManyTransfers)
+			}
+
+			return nil
+		}
+
+		t.timer = time.AfterFunc(r.RetransmitTimeout, func() {
+			r.timeout(t)
+		})
+
+		r.transfers[id] = t
+	}
+
+	// handle manifest
+	if part == "manifest" && t.manifest == nil {
+		var manifest Manifest
+		if json.Unmarshal(msg.Payload, &manifest) != nil || !r.valid(&manifest) {
+			t.timer.Stop()
+			delete(r.transfers, t.id)
+			r.status(t, &transferStatus{Error: ErrInvalidManifest.Error()})
+			r.router.service.err("Transfer", ErrInvalidManifest)
+			return nil
+		}
+
+		t.manifest = &manifest
+
+		// drop chunks that are not part of the transfer
+		for index, chunk := range t.chunks {
+			if index >= manifest.Chunks {
+				delete(t.chunks, index)
+				t.size -= int64(len(chunk))
+			}
+		}
+	} else if index, err := strconv.Atoi(part); err == nil && index >= 0 {
+		r.add(t, index, msg.Payload)
+	}
+
+	// check completion
+	if t.manifest != nil && len(t.chunks) >= t.manifest.Chunks && r.missing(t) == nil {
+		r.complete(t)
+		return nil
+	}
+
+	// delay timeout
+	t.timer.Reset(r.RetransmitTimeout)
+
+	return nil
+}
+
+// returns whether the manifest is consistent and within the limits
+func (r *Receiver) valid(m *Manifest) bool {
+	// check ranges
+	if m.Size < 0 || m.Chunks < 0 || m.Size > r.MaxSize || m.Chunks > r.MaxChunks {
+		return false
+	}
+
+	// check chunks, every chunk must contain at least one byte and at most
+	// the maximum chunk size
+	if int64(m.Chunks) > m.Size || m.Size > int64(m.Chunks)*int64(r.MaxChunkSize) {
+		return false
+	}
+
+	return true
+}
+
+// buffers a chunk if it is within the limits of the transfer
+func (r *Receiver) add(t *transfer, index int, chunk []byte) {
+	// get limit
+	limit := r.MaxSize
+	if t.manifest != nil {
+		limit = t.manifest.Size
+	}
+
+	// check index and size, empty chunks are never sent
+	if t.manifest != nil && index >= t.manifest.Chunks {
+		return
+	} else if index >= r.MaxChunks || len(chunk) == 0 || len(chunk) > r.MaxChunkSize {
+		return
+	}
+
+	// check total size
+	size := t.size - int64(len(t.chunks[index])) + int64(len(chunk))
+	if size > limit {
+		return
+	}
+
+	t.chunks[index] = chunk
+	t.size = size
+}
+
+// returns the indexes of missing chunks
+func (r *Receiver) missing(t *transfer) []int {
+	var missing []int
+	for i := 0; i < t.manifest.Chunks; i++ {
+		if _, ok := t.chunks[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+
+	return missing
+}
+
+// reassembles the data and reports the result
+func (r *Receiver) complete(t *transfer) {
+	// remove transfer
+	t.timer.Stop()
+	delete(r.transfers, t.id)
+
+	// reassemble data
+	data := make([]byte, 0, t.size)
+	for i := 0; i < t.manifest.Chunks; i++ {
+		data = append(data, t.chunks[i]...)
+	}
+
+	// verify checksum
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != t.manifest.Checksum {
+		r.status(t, &transferStatus{Error: ErrChecksumMismatch.Error()})
+		r.router.service.err("Transfer", ErrChecksumMismatch)
+		return
+	}
+
+	// call callback
+	if r.callback != nil {
+		err := r.callback(t.manifest, data)
+		if err != nil {
+			r.status(t, &transferStatus{Error: err.Error()})
+			return
+		}
+	}
+
+	r.status(t, &transferStatus{Complete: true})
+}
+
+// requests missing chunks or abandons the transfer
+func (r *Receiver) timeout(t *transfer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// check if transfer is still active
+	if r.transfers[t.id] != t {
+		return
+	}
+
+	// drop transfers without a manifest silently
+	if t.manifest == nil {
+		delete(r.transfers, t.id)
+		return
+	}
+
+	// abandon transfer
+	if t.retransmits >= r.MaxRetransmits {
+		delete(r.transfers, t.id)
+		r.status(t, &transferStatus{Error: ErrTransferIncomplete.Error()})
+		r.router.service.err("Transfer", ErrTransferIncomplete)
+		return
+	}
+
+	// request missing chunks
+	t.retransmits++
+	r.status(t, &transferStatus{Missing: r.missing(t)})
+	t.timer.Reset(r.RetransmitTimeout)
+}
+
+// publishes a status for the transfer
+func (r *Receiver) status(t *transfer, status *transferStatus) {
+	payload, _ := json.Marshal(status)
+	r.router.service.Publish(r.topic+"/"+t.id+"/status", payload, 1, false)
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/broker"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransfer(t *testing.T) {
+	port, quit, done := broker.Run(broker.NewEngine(), "tcp")
+
+	data := make([]byte, 100*1024)
+	rand.Read(data)
+
+	received := make(chan []byte, 1)
+
+	rs := NewService()
+	rr := NewRouter(rs)
+	r, sf := NewReceiver(rr, "files", func(manifest *Manifest, payload []byte) error {
+		assert.Equal(t, int64(len(data)), manifest.Size)
+		received <- payload
+		return nil
+	})
+	r.RetransmitTimeout = 100 * time.Millisecond
+
+	// drop the first delivery of a chunk
+	dropped := false
+	dispatch := rs.MessageCallback
+	rs.MessageCallback = func(msg *packet.Message) error {
+		if strings.HasSuffix(msg.Topic, "/2") && !dropped {
+			dropped = true
+			return nil
+		}
+
+		return dispatch(msg)
+	}
+
+	rs.Start(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, sf.Wait(time.Second))
+
+	ss := NewService()
+	s := NewSender(NewRouter(ss), "files")
+	s.ChunkSize = 16 * 1024
+
+	ss.Start(NewConfig("tcp://localhost:" + port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	manifest, err := s.Send(ctx, bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, manifest.Chunks)
+	assert.True(t, dropped)
+
+	select {
+	case payload := <-received:
+		assert.Equal(t, data, payload)
+	case <-time.After(time.Second):
+		assert.Fail(t, "transfer not received")
+	}
+
+	ss.Stop(true)
+	rs.Stop(true)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTransferChecksumMismatch(t *testing.T) {
+	s := NewService()
+	r, _ := NewReceiver(NewRouter(s), "files", nil)
+	s.Buffer = NewMemoryBuffer(0)
+
+	errs := make(chan error, 1)
+	s.ErrorCallback = func(err error) {
+		errs <- err
+	}
+
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/0", Payload: []byte("foo")}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/manifest", Payload: []byte(`{"id":"1","size":3,"chunks":1,"sha256":"00"}`)}))
+	assert.Equal(t, ErrChecksumMismatch, <-errs)
+
+	msg, err := s.Buffer.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, "files/1/status", msg.Topic)
+	assert.Equal(t, `{"error":"checksum mismatch"}`, string(msg.Payload))
+}
+
+func TestTransferInvalidManifest(t *testing.T) {
+	for _, manifest := range []string{
+		`foo`,
+		`{"id":"1","size":-1,"chunks":1,"sha256":"00"}`,
+		`{"id":"1","size":3,"chunks":-1,"sha256":"00"}`,
+		`{"id":"1","size":1099511627776,"chunks":1048576,"sha256":"00"}`,
+		`{"id":"1","size":3,"chunks":1000000000,"sha256":"00"}`,
+		`{"id":"1","size":3000000,"chunks":1,"sha256":"00"}`,
+		`{"id":"1","size":3,"chunks":0,"sha256":"00"}`,
+		`{"id":"1","size":1048576,"chunks":1048576,"sha256":"00"}`,
+	} {
+		s := NewService()
+		r, _ := NewReceiver(NewRouter(s), "files", func(*Manifest, []byte) error {
+			assert.Fail(t, "unexpected callback")
+			return nil
+		})
+		s.Buffer = NewMemoryBuffer(0)
+
+		errs := make(chan error, 1)
+		s.ErrorCallback = func(err error) {
+			errs <- err
+		}
+
+		assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/0", Payload: []byte("foo")}))
+		assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/manifest", Payload: []byte(manifest)}))
+		assert.Equal(t, ErrInvalidManifest, <-errs, manifest)
+		assert.Empty(t, r.transfers, manifest)
+
+		msg, err := s.Buffer.Front()
+		assert.NoError(t, err)
+		assert.Equal(t, "files/1/status", msg.Topic)
+		assert.Equal(t, `{"error":"invalid manifest"}`, string(msg.Payload))
+	}
+}
+
+func TestTransferInvalidChunks(t *testing.T) {
+	s := NewService()
+	s.Buffer = NewMemoryBuffer(0)
+
+	received := make(chan []byte, 1)
+	r, _ := NewReceiver(NewRouter(s), "files", func(manifest *Manifest, data []byte) error {
+		received <- data
+		return nil
+	})
+	r.MaxSize = 8
+	r.MaxChunkSize = 4
+	r.MaxChunks = 4
+
+	// chunks exceeding the limits are dropped
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/0", Payload: []byte("foo")}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/1", Payload: []byte("barbaz")}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/2", Payload: []byte{}}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/4", Payload: []byte("b")}))
+	assert.Len(t, r.transfers["1"].chunks, 1)
+
+	// chunks beyond the manifest are dropped
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/2", Payload: []byte("qux")}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/manifest", Payload: []byte(`{"id":"1","size":6,"chunks":2,"sha256":"c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"}`)}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/3", Payload: []byte("qux")}))
+	assert.Len(t, r.transfers["1"].chunks, 1)
+	assert.Equal(t, int64(3), r.transfers["1"].size)
+
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/1", Payload: []byte("bar")}))
+	assert.Equal(t, []byte("foobar"), <-received)
+	assert.Empty(t, r.transfers)
+}
+
+func TestTransferTooManyTransfers(t *testing.T) {
+	s := NewService()
+	r, _ := NewReceiver(NewRouter(s), "files", nil)
+	r.MaxTransfers = 1
+	s.Buffer = NewMemoryBuffer(0)
+
+	errs := make(chan error, 1)
+	s.ErrorCallback = func(err error) {
+		errs <- err
+	}
+
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/0", Payload: []byte("foo")}))
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/2/0", Payload: []byte("foo")}))
+	assert.Len(t, r.transfers, 1)
+
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/2/manifest", Payload: []byte(`{"id":"2","size":3,"chunks":1,"sha256":"00"}`)}))
+	assert.Equal(t, ErrTooManyTransfers, <-errs)
+	assert.Len(t, r.transfers, 1)
+
+	msg, err := s.Buffer.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, "files/2/status", msg.Topic)
+	assert.Equal(t, `{"error":"too many transfers"}`, string(msg.Payload))
+}
+
+func TestTransferAbandoned(t *testing.T) {
+	s := NewService()
+	r, _ := NewReceiver(NewRouter(s), "files", nil)
+	r.RetransmitTimeout = 10 * time.Millisecond
+	r.MaxRetransmits = 0
+	s.Buffer = NewMemoryBuffer(0)
+
+	errs := make(chan error, 1)
+	s.ErrorCallback = func(err error) {
+		errs <- err
+	}
+
+	assert.NoError(t, r.router.Dispatch(&packet.Message{Topic: "files/1/manifest", Payload: []byte(`{"id":"1","size":3,"chunks":1,"sha256":"00"}`)}))
+	assert.Equal(t, ErrTransferIncomplete, <-errs)
+
+	msg, err := s.Buffer.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, "files/1/status", msg.Topic)
+	assert.Equal(t, `{"error":"transfer incomplete"}`, string(msg.Payload))
+}
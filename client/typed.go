@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/256dpi/gomqtt/client/codec"
+	"github.com/256dpi/gomqtt/packet"
+)
+
+var messageType = reflect.TypeOf(&packet.Message{})
+var paramsType = reflect.TypeOf(Params{})
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// A DecodeError is emitted using the ErrorCallback of the service if the
+// payload of a message received by a typed handler cannot be decoded.
+//
+// Note: MQTT 3.1.1 has no properties to carry the content type. Publishers and
+// subscribers must therefore agree on the codec used for a topic.
+type DecodeError struct {
+	Topic       string
+	ContentType string
+	Err         error
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s payload of %q: %s", e.ContentType, e.Topic, e.Err.Error())
+}
+
+// PublishCodec will encode the value using the codec and publish it. It will
+// return a GenericFuture that gets completed once the quality of service flow
+// has been completed or an error if the value could not be encoded.
+func (s *Service) PublishCodec(topic string, value interface{}, c codec.Codec, qos uint8, retain bool) (GenericFuture, error) {
+	// encode value
+	payload, err := c.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Publish(topic, payload, qos, retain), nil
+}
+
+// PublishJSON will encode the value as JSON and publish it.
+func (s *Service) PublishJSON(topic string, value interface{}, qos uint8, retain bool) (GenericFuture, error) {
+	return s.PublishCodec(topic, value, codec.JSON, qos, retain)
+}
+
+// HandleCodec will register a typed handler for the specified pattern like
+// Handle. The handler must be a function of the following form where T is the
+// type the payload is decoded into using the codec:
+//
+//	func(msg *packet.Message, params Params, value *T) error
+//
+// Messages that cannot be decoded are acknowledged and a DecodeError is
+// emitted using the ErrorCallback of the service.
+//
+// Note: The method will panic if the pattern or the handler is invalid.
+func (r *Router) HandleCodec(pattern string, qos uint8, c codec.Codec, handler interface{}) SubscribeFuture {
+	// check handler
+	fn := reflect.ValueOf(handler)
+	typ := fn.Type()
+	if typ.Kind() != reflect.Func || typ.NumIn() != 3 || typ.NumOut() != 1 ||
+		typ.In(0) != messageType || typ.In(1) != paramsType ||
+		typ.In(2).Kind() != reflect.Ptr || typ.Out(0) != errorType {
+		panic(fmt.Sprintf("invalid handler %s", typ.String()))
+	}
+
+	// get value type
+	valueType := typ.In(2).Elem()
+
+	return r.Handle(pattern, qos, func(msg *packet.Message, params Params) error {
+		// decode payload
+		value := reflect.New(valueType)
+		err := c.Decode(msg.Payload, value.Interface())
+		if err != nil {
+			r.service.err("Decode", &DecodeError{
+				Topic:       msg.Topic,
+				ContentType: c.ContentType(),
+				Err:         err,
+			})
+
+			return nil
+		}
+
+		// call handler
+		out := fn.Call([]reflect.Value{reflect.ValueOf(msg), reflect.ValueOf(params), value})
+		if err, ok := out[0].Interface().(error); ok {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// HandleJSON will register a typed handler that receives JSON encoded values.
+// See HandleCodec for details.
+func (r *Router) HandleJSON(pattern string, qos uint8, handler interface{}) SubscribeFuture {
+	return r.HandleCodec(pattern, qos, codec.JSON, handler)
+}
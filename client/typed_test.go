@@ -0,0 +1,69 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/256dpi/gomqtt/client/codec"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+type typedValue struct {
+	Value int `json:"value"`
+}
+
+func TestServicePublishCodec(t *testing.T) {
+	s := NewService()
+	s.Buffer = NewMemoryBuffer(0)
+
+	f, err := s.PublishJSON("test", typedValue{Value: 1}, 1, false)
+	assert.NoError(t, err)
+	assert.NotNil(t, f)
+
+	f, err = s.PublishCodec("test", typedValue{Value: 2}, codec.MsgPack, 0, false)
+	assert.NoError(t, err)
+	assert.NotNil(t, f)
+
+	_, err = s.PublishJSON("test", func() {}, 0, false)
+	assert.Error(t, err)
+
+	msg, err := s.Buffer.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, &packet.Message{Topic: "test", Payload: []byte(`{"value":1}`), QOS: 1}, msg)
+	assert.NoError(t, s.Buffer.Shift())
+
+	msg, err = s.Buffer.Front()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x81, 0xa5, 'v', 'a', 'l', 'u', 'e', 0x02}, msg.Payload)
+}
+
+func TestRouterHandleCodec(t *testing.T) {
+	s := NewService()
+	r := NewRouter(s)
+
+	var errs []error
+	s.ErrorCallback = func(err error) {
+		errs = append(errs, err)
+	}
+
+	var values []int
+	r.HandleJSON("devices/{id}", 0, func(msg *packet.Message, params Params, value *typedValue) error {
+		assert.Equal(t, "d1", params["id"])
+		values = append(values, value.Value)
+		return nil
+	})
+
+	assert.NoError(t, r.Dispatch(&packet.Message{Topic: "devices/d1", Payload: []byte(`{"value":7}`)}))
+	assert.NoError(t, r.Dispatch(&packet.Message{Topic: "devices/d1", Payload: []byte(`{`)}))
+	assert.Equal(t, []int{7}, values)
+
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "devices/d1", errs[0].(*DecodeError).Topic)
+	assert.Equal(t, "application/json", errs[0].(*DecodeError).ContentType)
+
+	assert.Panics(t, func() {
+		r.HandleJSON("foo", 0, func(value *typedValue) error {
+			return nil
+		})
+	})
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A KeyFunc returns the key used to order the processing of messages. Messages
+// with the same key are processed in the order they have been received.
+type KeyFunc func(*packet.Message) string
+
+// TopicKey is the default KeyFunc that orders messages per topic.
+func TopicKey(msg *packet.Message) string {
+	return msg.Topic
+}
+
+type job struct {
+	client *Client
+	msg    *packet.Message
+}
+
+// a workerPool calls the message callback concurrently while messages with the
+// same key are always handled by the same worker
+type workerPool struct {
+	callback MessageCallback
+	key      KeyFunc
+	queues   []chan job
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newWorkerPool(workers, queueSize int, callback MessageCallback, key KeyFunc) *workerPool {
+	// set default key func
+	if key == nil {
+		key = TopicKey
+	}
+
+	p := &workerPool{
+		callback: callback,
+		key:      key,
+		queues:   make([]chan job, workers),
+		quit:     make(chan struct{}),
+	}
+
+	// run workers
+	for i := range p.queues {
+		p.queues[i] = make(chan job, queueSize)
+		p.wg.Add(1)
+		go p.worker(p.queues[i])
+	}
+
+	return p
+}
+
+// dispatch will queue the message for the worker responsible for its key. It
+// blocks while the queue of the worker is full.
+func (p *workerPool) dispatch(client *Client, msg *packet.Message) {
+	// select queue
+	hash := fnv.New32a()
+	hash.Write([]byte(p.key(msg)))
+	queue := p.queues[hash.Sum32()%uint32(len(p.queues))]
+
+	// queue message
+	select {
+	case queue <- job{client: client, msg: msg}:
+	case <-p.quit:
+	}
+}
+
+func (p *workerPool) worker(queue chan job) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case j := <-queue:
+			// call callback
+			if p.callback != nil {
+				err := p.callback(j.msg)
+				if err != nil {
+					// close client without acknowledging the message
+					j.client.die(err, true, false)
+					continue
+				}
+			}
+
+			// acknowledge message
+			if j.msg.QOS > 0 {
+				j.client.Ack(j.msg)
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// stop will stop the workers and wait until they returned. Queued messages
+// are dropped without being acknowledged.
+func (p *workerPool) stop() {
+	// check pool
+	if p == nil {
+		return
+	}
+
+	close(p.quit)
+	p.wg.Wait()
+}
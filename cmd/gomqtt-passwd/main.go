@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/256dpi/gomqtt/broker"
+)
+
+var create = flag.Bool("c", false, "create a new password file")
+var remove = flag.Bool("D", false, "delete the user from the password file")
+var algorithm = flag.String("a", broker.PBKDF2, "hash algorithm (bcrypt, pbkdf2 or argon2)")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gomqtt-passwd [-c] [-D] [-a algorithm] file user [password]")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() < 2 || flag.NArg() > 3 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	path := flag.Arg(0)
+	user := flag.Arg(1)
+
+	if user == "" || strings.Contains(user, ":") {
+		fail("invalid username")
+	}
+
+	hashes := make(map[string]string)
+
+	// load existing file
+	if !*create {
+		file, err := os.Open(path)
+		if err != nil {
+			fail(err.Error())
+		}
+
+		hashes, err = broker.ParsePasswords(file)
+		file.Close()
+		if err != nil {
+			fail(err.Error())
+		}
+	}
+
+	if *remove {
+		if _, ok := hashes[user]; !ok {
+			fail("user not found")
+		}
+
+		delete(hashes, user)
+	} else {
+		password := flag.Arg(2)
+
+		// read password from stdin if missing
+		if flag.NArg() < 3 {
+			fmt.Fprint(os.Stderr, "Password: ")
+
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fail(err.Error())
+			}
+
+			password = strings.TrimRight(line, "\r\n")
+		}
+
+		hash, err := broker.HashPassword(password, *algorithm)
+		if err != nil {
+			fail(err.Error())
+		}
+
+		hashes[user] = hash
+	}
+
+	err := broker.WritePasswords(path, hashes)
+	if err != nil {
+		fail(err.Error())
+	}
+}
+
+func fail(msg string) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+	os.Exit(1)
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -55,3 +56,24 @@ type Conn interface {
 	// RemoteAddr will return the underlying connection's remote net address.
 	RemoteAddr() net.Addr
 }
+
+// A TLSConn is a Conn that provides access to the state of an underlying TLS
+// connection. It is implemented by NetConn and WebSocketConn.
+type TLSConn interface {
+	Conn
+
+	// TLSConnectionState will return the state of the underlying TLS
+	// connection and true, or false if the connection is not secured by TLS.
+	// The state includes any verified client certificates.
+	TLSConnectionState() (tls.ConnectionState, bool)
+}
+
+// returns the connection state if the net.Conn is a tls.Conn
+func tlsConnectionState(conn net.Conn) (tls.ConnectionState, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+
+	return tlsConn.ConnectionState(), true
+}
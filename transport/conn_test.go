@@ -226,6 +226,34 @@ func abstractConnAddrTest(t *testing.T, protocol string) {
 	safeReceive(done)
 }
 
+func abstractConnTLSConnectionStateTest(t *testing.T, protocol string, secure bool) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		pkt, err := conn1.Receive()
+		assert.Equal(t, packet.CONNECT, pkt.Type())
+		assert.NoError(t, err)
+
+		state, ok := conn1.(TLSConn).TLSConnectionState()
+		assert.Equal(t, secure, ok)
+		assert.Equal(t, secure, state.HandshakeComplete)
+
+		err = conn1.Close()
+		assert.NoError(t, err)
+	})
+
+	err := conn2.Send(packet.NewConnectPacket())
+	assert.NoError(t, err)
+
+	state, ok := conn2.(TLSConn).TLSConnectionState()
+	assert.Equal(t, secure, ok)
+	assert.Equal(t, secure, state.HandshakeComplete)
+
+	pkt, err := conn2.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	safeReceive(done)
+}
+
 func abstractConnBufferedSendTest(t *testing.T, protocol string) {
 	conn2, done := connectionPair(protocol, func(conn1 Conn) {
 		pkt, err := conn1.Receive()
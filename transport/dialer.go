@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -10,7 +11,13 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// A DialFunc dials a connection for a custom URL scheme.
+type DialFunc func(ctx context.Context, url *url.URL) (Conn, error)
+
 // The Dialer handles connecting to a server and creating a connection.
+//
+// Additional URL schemes can be supported by registering a DialFunc using
+// Register, which is consulted before the built-in schemes.
 type Dialer struct {
 	TLSConfig     *tls.Config
 	RequestHeader http.Header
@@ -20,6 +27,7 @@ type Dialer struct {
 	DefaultWSPort  string
 	DefaultWSSPort string
 
+	schemes         map[string]DialFunc
 	webSocketDialer *websocket.Dialer
 }
 
@@ -37,6 +45,34 @@ func NewDialer() *Dialer {
 	}
 }
 
+// Register will register a DialFunc for the specified URL scheme. It must be
+// called before the dialer is used.
+func (d *Dialer) Register(scheme string, fn DialFunc) {
+	if d.schemes == nil {
+		d.schemes = make(map[string]DialFunc)
+	}
+
+	d.schemes[scheme] = fn
+}
+
+// Clone will return a new dialer with the same configuration and registered
+// schemes. The internal state of the dialer is not shared.
+func (d *Dialer) Clone() *Dialer {
+	c := NewDialer()
+	c.TLSConfig = d.TLSConfig
+	c.RequestHeader = d.RequestHeader
+	c.DefaultTCPPort = d.DefaultTCPPort
+	c.DefaultTLSPort = d.DefaultTLSPort
+	c.DefaultWSPort = d.DefaultWSPort
+	c.DefaultWSSPort = d.DefaultWSSPort
+
+	for scheme, fn := range d.schemes {
+		c.Register(scheme, fn)
+	}
+
+	return c
+}
+
 var sharedDialer *Dialer
 
 func init() {
@@ -48,13 +84,30 @@ func Dial(urlString string) (Conn, error) {
 	return sharedDialer.Dial(urlString)
 }
 
+// DialContext is a shorthand function.
+func DialContext(ctx context.Context, urlString string) (Conn, error) {
+	return sharedDialer.DialContext(ctx, urlString)
+}
+
 // Dial initiates a connection based in information extracted from an URL.
 func (d *Dialer) Dial(urlString string) (Conn, error) {
+	return d.DialContext(context.Background(), urlString)
+}
+
+// DialContext initiates a connection based in information extracted from an
+// URL. An in-progress dial is aborted if the context is canceled or its
+// deadline is exceeded.
+func (d *Dialer) DialContext(ctx context.Context, urlString string) (Conn, error) {
 	urlParts, err := url.ParseRequestURI(urlString)
 	if err != nil {
 		return nil, err
 	}
 
+	// check custom schemes
+	if fn, ok := d.schemes[urlParts.Scheme]; ok {
+		return fn(ctx, urlParts)
+	}
+
 	host, port, err := net.SplitHostPort(urlParts.Host)
 	if err != nil {
 		host = urlParts.Host
@@ -67,7 +120,7 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 			port = d.DefaultTCPPort
 		}
 
-		conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		conn, err := new(net.Dialer).DialContext(ctx, "tcp", net.JoinHostPort(host, port))
 		if err != nil {
 			return nil, err
 		}
@@ -78,7 +131,7 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 			port = d.DefaultTLSPort
 		}
 
-		conn, err := tls.Dial("tcp", net.JoinHostPort(host, port), d.TLSConfig)
+		conn, err := dialTLS(ctx, net.JoinHostPort(host, port), d.TLSConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -91,7 +144,7 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 
 		wsURL := fmt.Sprintf("ws://%s:%s%s", host, port, urlParts.Path)
 
-		conn, _, err := d.webSocketDialer.Dial(wsURL, d.RequestHeader)
+		conn, _, err := d.webSocketDialer.DialContext(ctx, wsURL, d.RequestHeader)
 		if err != nil {
 			return nil, err
 		}
@@ -105,7 +158,7 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 		wsURL := fmt.Sprintf("wss://%s:%s%s", host, port, urlParts.Path)
 
 		d.webSocketDialer.TLSClientConfig = d.TLSConfig
-		conn, _, err := d.webSocketDialer.Dial(wsURL, d.RequestHeader)
+		conn, _, err := d.webSocketDialer.DialContext(ctx, wsURL, d.RequestHeader)
 		if err != nil {
 			return nil, err
 		}
@@ -115,3 +168,50 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 
 	return nil, ErrUnsupportedProtocol
 }
+
+// dials a tls connection and performs the handshake while respecting the context
+func dialTLS(ctx context.Context, addr string, config *tls.Config) (*tls.Conn, error) {
+	// dial connection
+	rawConn, err := new(net.Dialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare config
+	if config == nil {
+		config = &tls.Config{}
+	}
+
+	// set server name if missing
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	// create connection
+	conn := tls.Client(rawConn, config)
+
+	// perform handshake
+	errs := make(chan error, 1)
+	go func() {
+		errs <- conn.Handshake()
+	}()
+
+	// wait for handshake or context
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		rawConn.Close()
+		<-errs
+		err = ctx.Err()
+	}
+
+	// check error
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
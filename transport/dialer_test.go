@@ -1,8 +1,12 @@
 package transport
 
 import (
+	"context"
 	"io"
+	"net"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -37,6 +41,41 @@ func TestGlobalDial(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDialContext(t *testing.T) {
+	// a listener that never completes a handshake
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			_, err := listener.Accept()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, scheme := range []string{"tls", "ws"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		conn, err := DialContext(ctx, scheme+"://"+listener.Addr().String())
+		assert.Error(t, err)
+		assert.Nil(t, conn)
+
+		cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	conn, err := DialContext(ctx, "tcp://"+listener.Addr().String())
+	assert.Error(t, err)
+	assert.Nil(t, conn)
+
+	err = listener.Close()
+	assert.NoError(t, err)
+}
+
 func TestDialerBadURL(t *testing.T) {
 	conn, err := Dial("foo")
 	assert.Nil(t, conn)
@@ -49,6 +88,24 @@ func TestDialerUnsupportedProtocol(t *testing.T) {
 	assert.Equal(t, ErrUnsupportedProtocol, err)
 }
 
+func TestDialerCustomScheme(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	d := NewDialer()
+	d.Register("foo", func(ctx context.Context, url *url.URL) (Conn, error) {
+		assert.Equal(t, "bar", url.Host)
+		return NewNetConn(a), nil
+	})
+
+	conn, err := d.Clone().Dial("foo://bar")
+	assert.NoError(t, err)
+	assert.NotNil(t, conn)
+
+	err = conn.Close()
+	assert.NoError(t, err)
+}
+
 func TestDialerTCPError(t *testing.T) {
 	conn, err := Dial("tcp://localhost:1234567")
 	assert.Nil(t, conn)
@@ -8,6 +8,9 @@ import (
 // The Launcher helps with launching a server and accepting connections.
 type Launcher struct {
 	TLSConfig *tls.Config
+
+	// ProxyConfig enables the parsing of PROXY protocol headers if set.
+	ProxyConfig *ProxyConfig
 }
 
 // NewLauncher returns a new Launcher.
@@ -33,6 +36,22 @@ func (l *Launcher) Launch(urlString string) (Server, error) {
 		return nil, err
 	}
 
+	// launch proxy servers if configured
+	if l.ProxyConfig != nil {
+		switch urlParts.Scheme {
+		case "tcp", "mqtt":
+			return NewProxyNetServer(urlParts.Host, l.ProxyConfig)
+		case "tls", "mqtts":
+			return NewSecureProxyNetServer(urlParts.Host, l.TLSConfig, l.ProxyConfig)
+		case "ws":
+			return NewProxyWebSocketServer(urlParts.Host, l.ProxyConfig)
+		case "wss":
+			return NewSecureProxyWebSocketServer(urlParts.Host, l.TLSConfig, l.ProxyConfig)
+		}
+
+		return nil, ErrUnsupportedProtocol
+	}
+
 	switch urlParts.Scheme {
 	case "tcp", "mqtt":
 		return NewNetServer(urlParts.Host)
@@ -1,6 +1,9 @@
 package transport
 
-import "net"
+import (
+	"crypto/tls"
+	"net"
+)
 
 // A NetConn is a wrapper around a basic TCP connection.
 type NetConn struct {
@@ -27,7 +30,19 @@ func (c *NetConn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// TLSConnectionState returns the state of the underlying TLS connection and
+// true, or false if the connection is not secured by TLS.
+func (c *NetConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return tlsConnectionState(c.conn)
+}
+
 // UnderlyingConn returns the underlying net.Conn.
 func (c *NetConn) UnderlyingConn() net.Conn {
 	return c.conn
 }
+
+// ProxyHeader returns the PROXY protocol header sent by a load balancer or nil
+// if the connection has not been accepted by a proxy server.
+func (c *NetConn) ProxyHeader() *ProxyHeader {
+	return proxyHeader(c.conn)
+}
@@ -52,6 +52,11 @@ func TestNetConnAddr(t *testing.T) {
 	abstractConnAddrTest(t, "tcp")
 }
 
+func TestNetConnTLSConnectionState(t *testing.T) {
+	abstractConnTLSConnectionStateTest(t, "tcp", false)
+	abstractConnTLSConnectionStateTest(t, "tls", true)
+}
+
 func TestNetConnBufferedSend(t *testing.T) {
 	abstractConnBufferedSendTest(t, "tcp")
 }
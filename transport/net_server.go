@@ -34,6 +34,32 @@ func NewSecureNetServer(address string, config *tls.Config) (*NetServer, error)
 	}, nil
 }
 
+// NewProxyNetServer creates a new TCP server that listens on the provided
+// address and parses PROXY protocol headers using the provided config.
+func NewProxyNetServer(address string, proxyConfig *ProxyConfig) (*NetServer, error) {
+	listener, err := listenProxy(address, proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NetServer{
+		listener: listener,
+	}, nil
+}
+
+// NewSecureProxyNetServer creates a new TLS server that listens on the provided
+// address and parses PROXY protocol headers using the provided config.
+func NewSecureProxyNetServer(address string, config *tls.Config, proxyConfig *ProxyConfig) (*NetServer, error) {
+	listener, err := listenProxy(address, proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NetServer{
+		listener: tls.NewListener(listener, config),
+	}, nil
+}
+
 // Accept will return the next available connection or block until a
 // connection becomes available, otherwise returns an Error.
 func (s *NetServer) Accept() (Conn, error) {
@@ -60,3 +86,19 @@ func (s *NetServer) Close() error {
 func (s *NetServer) Addr() net.Addr {
 	return s.listener.Addr()
 }
+
+// listens on the address and wraps the listener to parse PROXY protocol headers
+func listenProxy(address string, config *ProxyConfig) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyListener, err := newProxyListener(listener, config)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return proxyListener, nil
+}
@@ -0,0 +1,574 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidProxyHeader is returned when reading from a connection that
+// started with a malformed PROXY protocol header.
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+// ErrMissingProxyHeader is returned when reading from a connection of a trusted
+// source that did not send a required PROXY protocol header.
+var ErrMissingProxyHeader = errors.New("missing proxy protocol header")
+
+var proxyV1Signature = []byte("PROXY ")
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The known PROXY protocol v2 TLV types.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+// The known PROXY protocol v2 SSL sub TLV types.
+const (
+	ProxySubTLVSSLVersion byte = 0x21
+	ProxySubTLVSSLCN      byte = 0x22
+	ProxySubTLVSSLCipher  byte = 0x23
+	ProxySubTLVSSLSigAlg  byte = 0x24
+	ProxySubTLVSSLKeyAlg  byte = 0x25
+)
+
+// A ProxyConfig configures the parsing of PROXY protocol headers sent by load
+// balancers in front of a server.
+type ProxyConfig struct {
+	// TrustedSources is a list of IP addresses and CIDR networks that are
+	// allowed to send a PROXY protocol header. Connections from other sources
+	// are passed through unmodified. If empty, all sources are trusted.
+	TrustedSources []string
+
+	// HeaderTimeout is the maximum time allowed to read the header. If zero,
+	// the header read is only bounded by the connection's read deadline.
+	HeaderTimeout time.Duration
+
+	// Required will make connections from trusted sources fail if they do
+	// not start with a PROXY protocol header.
+	Required bool
+}
+
+// A ProxyTLV is an additional field transmitted with a v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// A ProxySSL holds the information about the TLS connection between the
+// client and the load balancer.
+type ProxySSL struct {
+	// Client holds the PP2_CLIENT_* bit field.
+	Client byte
+
+	// Verified is true if the client presented a certificate that has been
+	// successfully verified.
+	Verified bool
+
+	Version    string
+	CommonName string
+	Cipher     string
+	SigAlg     string
+	KeyAlg     string
+}
+
+// A ProxyHeader holds the information parsed from a PROXY protocol header.
+type ProxyHeader struct {
+	// Version is either 1 or 2.
+	Version byte
+
+	// Local is set if the header has been sent with the LOCAL command (e.g.
+	// for health checks) and the addresses should not be used.
+	Local bool
+
+	// The original source and destination address of the connection.
+	SourceAddr      net.Addr
+	DestinationAddr net.Addr
+
+	// TLVs holds the additional fields of v2 headers.
+	TLVs []ProxyTLV
+}
+
+// TLV will return the value of the first TLV with the specified type.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// SSL will parse and return the SSL TLV if present.
+func (h *ProxyHeader) SSL() (*ProxySSL, bool) {
+	// get tlv
+	value, ok := h.TLV(ProxyTLVSSL)
+	if !ok || len(value) < 5 {
+		return nil, false
+	}
+
+	// parse fixed fields
+	ssl := &ProxySSL{
+		Client:   value[0],
+		Verified: binary.BigEndian.Uint32(value[1:5]) == 0,
+	}
+
+	// parse sub tlvs
+	tlvs, err := parseProxyTLVs(value[5:])
+	if err != nil {
+		return nil, false
+	}
+
+	// assign known sub tlvs
+	for _, tlv := range tlvs {
+		switch tlv.Type {
+		case ProxySubTLVSSLVersion:
+			ssl.Version = string(tlv.Value)
+		case ProxySubTLVSSLCN:
+			ssl.CommonName = string(tlv.Value)
+		case ProxySubTLVSSLCipher:
+			ssl.Cipher = string(tlv.Value)
+		case ProxySubTLVSSLSigAlg:
+			ssl.SigAlg = string(tlv.Value)
+		case ProxySubTLVSSLKeyAlg:
+			ssl.KeyAlg = string(tlv.Value)
+		}
+	}
+
+	return ssl, true
+}
+
+// ReadProxyHeader will read a v1 or v2 PROXY protocol header from the reader.
+// It will return nil if the reader does not start with a header.
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	// peek first byte
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for v1 header
+	if first[0] == proxyV1Signature[0] {
+		sig, err := r.Peek(len(proxyV1Signature))
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(sig, proxyV1Signature) {
+			return readProxyHeaderV1(r)
+		}
+	}
+
+	// check for v2 header
+	if first[0] == proxyV2Signature[0] {
+		sig, err := r.Peek(len(proxyV2Signature))
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(sig, proxyV2Signature) {
+			return readProxyHeaderV2(r)
+		}
+	}
+
+	return nil, nil
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	// read line with a maximum size of 107 bytes
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		} else if len(line) >= 107 {
+			return nil, ErrInvalidProxyHeader
+		}
+	}
+
+	// check line ending
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// split fields
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+
+	// prepare header
+	header := &ProxyHeader{
+		Version: 1,
+	}
+
+	// handle unknown protocol
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+
+	// check fields
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// parse addresses
+	src, err := parseProxyAddrV1(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyAddrV1(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	// set addresses
+	header.SourceAddr = src
+	header.DestinationAddr = dst
+
+	return header, nil
+}
+
+func parseProxyAddrV1(ip, port string) (*net.TCPAddr, error) {
+	// parse ip
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// parse port
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// set port
+	addr.Port = int(p)
+
+	return addr, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	// read fixed part
+	fixed := make([]byte, 16)
+	_, err := io.ReadFull(r, fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	// check version
+	if fixed[12]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// read remaining part
+	rest := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	_, err = io.ReadFull(r, rest)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare header
+	header := &ProxyHeader{
+		Version: 2,
+	}
+
+	// check command
+	switch fixed[12] & 0x0F {
+	case 0x00:
+		header.Local = true
+	case 0x01:
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// parse addresses
+	var length int
+	switch fixed[13] >> 4 {
+	case 0x1:
+		length = 12
+		if len(rest) < length {
+			return nil, ErrInvalidProxyHeader
+		}
+
+		header.SourceAddr = &net.TCPAddr{
+			IP:   net.IP(rest[0:4]),
+			Port: int(binary.BigEndian.Uint16(rest[8:10])),
+		}
+		header.DestinationAddr = &net.TCPAddr{
+			IP:   net.IP(rest[4:8]),
+			Port: int(binary.BigEndian.Uint16(rest[10:12])),
+		}
+	case 0x2:
+		length = 36
+		if len(rest) < length {
+			return nil, ErrInvalidProxyHeader
+		}
+
+		header.SourceAddr = &net.TCPAddr{
+			IP:   net.IP(rest[0:16]),
+			Port: int(binary.BigEndian.Uint16(rest[32:34])),
+		}
+		header.DestinationAddr = &net.TCPAddr{
+			IP:   net.IP(rest[16:32]),
+			Port: int(binary.BigEndian.Uint16(rest[34:36])),
+		}
+	case 0x3:
+		length = 216
+		if len(rest) < length {
+			return nil, ErrInvalidProxyHeader
+		}
+
+		header.SourceAddr = &net.UnixAddr{
+			Name: string(bytes.TrimRight(rest[0:108], "\x00")),
+			Net:  "unix",
+		}
+		header.DestinationAddr = &net.UnixAddr{
+			Name: string(bytes.TrimRight(rest[108:216], "\x00")),
+			Net:  "unix",
+		}
+	default:
+		// unspecified addresses are ignored
+		header.Local = true
+	}
+
+	// parse tlvs
+	header.TLVs, err = parseProxyTLVs(rest[length:])
+	if err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+func parseProxyTLVs(data []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+
+	for len(data) > 0 {
+		// check fixed size
+		if len(data) < 3 {
+			return nil, ErrInvalidProxyHeader
+		}
+
+		// get length
+		length := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, ErrInvalidProxyHeader
+		}
+
+		// add tlv
+		tlvs = append(tlvs, ProxyTLV{
+			Type:  data[0],
+			Value: data[3 : 3+length],
+		})
+
+		// advance
+		data = data[3+length:]
+	}
+
+	return tlvs, nil
+}
+
+// a proxyListener wraps accepted connections in proxyConns
+type proxyListener struct {
+	net.Listener
+
+	config  *ProxyConfig
+	trusted []*net.IPNet
+}
+
+func newProxyListener(listener net.Listener, config *ProxyConfig) (*proxyListener, error) {
+	// prepare listener
+	l := &proxyListener{
+		Listener: listener,
+		config:   config,
+	}
+
+	// parse trusted sources
+	for _, source := range config.TrustedSources {
+		// add single addresses as full networks
+		if !strings.Contains(source, "/") {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: source}
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+
+			l.trusted = append(l.trusted, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+
+			continue
+		}
+
+		// parse network
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, err
+		}
+
+		l.trusted = append(l.trusted, network)
+	}
+
+	return l, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	// pass through untrusted connections
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &proxyConn{
+		Conn:   conn,
+		config: l.config,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	// trust all if no sources have been configured
+	if len(l.trusted) == 0 {
+		return true
+	}
+
+	// get ip
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	// check networks
+	for _, network := range l.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a proxyConn lazily reads the PROXY protocol header on first use
+type proxyConn struct {
+	net.Conn
+
+	config *ProxyConfig
+	reader *bufio.Reader
+
+	once     sync.Once
+	header   *ProxyHeader
+	err      error
+	deadline time.Time
+	mutex    sync.Mutex
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		// set header timeout
+		if c.config.HeaderTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.config.HeaderTimeout))
+		}
+
+		// read header
+		c.header, c.err = ReadProxyHeader(c.reader)
+		if c.err == nil && c.header == nil && c.config.Required {
+			c.err = ErrMissingProxyHeader
+		}
+
+		// restore deadline
+		if c.config.HeaderTimeout > 0 {
+			c.mutex.Lock()
+			c.Conn.SetReadDeadline(c.deadline)
+			c.mutex.Unlock()
+		}
+
+		// close connection on error
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// Header will return the parsed header or nil if not available.
+func (c *proxyConn) Header() *ProxyHeader {
+	c.readHeader()
+
+	return c.header
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+
+	if c.header != nil && !c.header.Local && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// returns the proxy header of a possibly wrapped net.Conn
+func proxyHeader(conn net.Conn) *ProxyHeader {
+	for {
+		switch c := conn.(type) {
+		case *proxyConn:
+			return c.Header()
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
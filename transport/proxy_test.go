@@ -0,0 +1,322 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyHeaderV2(tlvs []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(0x21)
+	buf.WriteByte(0x11)
+	binary.Write(buf, binary.BigEndian, uint16(12+len(tlvs)))
+	buf.Write([]byte{10, 0, 0, 1})
+	buf.Write([]byte{10, 0, 0, 2})
+	binary.Write(buf, binary.BigEndian, uint16(5000))
+	binary.Write(buf, binary.BigEndian, uint16(1883))
+	buf.Write(tlvs)
+	return buf.Bytes()
+}
+
+func proxyTLV(typ byte, value []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(typ)
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.Write(value)
+	return buf.Bytes()
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 10.0.0.1 10.0.0.2 5000 1883\r\nfoo"))
+
+	header, err := ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), header.Version)
+	assert.False(t, header.Local)
+	assert.Equal(t, "10.0.0.1:5000", header.SourceAddr.String())
+	assert.Equal(t, "10.0.0.2:1883", header.DestinationAddr.String())
+
+	rest := make([]byte, 3)
+	_, err = r.Read(rest)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), rest)
+}
+
+func TestReadProxyHeaderV1Unknown(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n"))
+
+	header, err := ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.True(t, header.Local)
+	assert.Nil(t, header.SourceAddr)
+}
+
+func TestReadProxyHeaderV1Invalid(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 foo 10.0.0.2 5000 1883\r\n"))
+
+	header, err := ReadProxyHeader(r)
+	assert.Equal(t, ErrInvalidProxyHeader, err)
+	assert.Nil(t, header)
+
+	r = bufio.NewReader(strings.NewReader("PROXY " + strings.Repeat("A", 200)))
+
+	header, err = ReadProxyHeader(r)
+	assert.Equal(t, ErrInvalidProxyHeader, err)
+	assert.Nil(t, header)
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	ssl := []byte{0x07, 0, 0, 0, 0}
+	ssl = append(ssl, proxyTLV(ProxySubTLVSSLVersion, []byte("TLSv1.2"))...)
+	ssl = append(ssl, proxyTLV(ProxySubTLVSSLCN, []byte("device1"))...)
+
+	tlvs := proxyTLV(ProxyTLVAuthority, []byte("example.com"))
+	tlvs = append(tlvs, proxyTLV(ProxyTLVSSL, ssl)...)
+
+	r := bufio.NewReader(bytes.NewReader(proxyHeaderV2(tlvs)))
+
+	header, err := ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, byte(2), header.Version)
+	assert.False(t, header.Local)
+	assert.Equal(t, "10.0.0.1:5000", header.SourceAddr.String())
+	assert.Equal(t, "10.0.0.2:1883", header.DestinationAddr.String())
+
+	authority, ok := header.TLV(ProxyTLVAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("example.com"), authority)
+
+	info, ok := header.SSL()
+	assert.True(t, ok)
+	assert.Equal(t, &ProxySSL{
+		Client:     0x07,
+		Verified:   true,
+		Version:    "TLSv1.2",
+		CommonName: "device1",
+	}, info)
+}
+
+func TestReadProxyHeaderV2Invalid(t *testing.T) {
+	data := proxyHeaderV2(nil)
+	data = data[:len(data)-2]
+
+	r := bufio.NewReader(bytes.NewReader(data))
+
+	header, err := ReadProxyHeader(r)
+	assert.Error(t, err)
+	assert.Nil(t, header)
+
+	data = proxyHeaderV2([]byte{0x01, 0x00})
+	binary.BigEndian.PutUint16(data[14:16], 14)
+
+	r = bufio.NewReader(bytes.NewReader(data))
+
+	header, err = ReadProxyHeader(r)
+	assert.Equal(t, ErrInvalidProxyHeader, err)
+	assert.Nil(t, header)
+}
+
+func TestReadProxyHeaderMissing(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte{0x10, 0x00}))
+
+	header, err := ReadProxyHeader(r)
+	assert.NoError(t, err)
+	assert.Nil(t, header)
+}
+
+func abstractProxyServerTest(t *testing.T, protocol string, header []byte, remote string) {
+	launcher := NewLauncher()
+	launcher.ProxyConfig = &ProxyConfig{
+		TrustedSources: []string{"127.0.0.1", "::1/128"},
+		HeaderTimeout:  time.Second,
+	}
+
+	server, err := launcher.Launch(protocol + "://localhost:0")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		conn, err := server.Accept()
+		require.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.Equal(t, packet.CONNECT, pkt.Type())
+		assert.NoError(t, err)
+
+		assert.Equal(t, remote, conn.RemoteAddr().String())
+
+		if header != nil {
+			assert.NotNil(t, conn.(interface {
+				ProxyHeader() *ProxyHeader
+			}).ProxyHeader())
+		}
+
+		err = conn.Close()
+		assert.NoError(t, err)
+
+		close(done)
+	}()
+
+	dial := func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = conn.Write(header)
+		return conn, err
+	}
+
+	var conn Conn
+	if protocol == "ws" {
+		dialer := &websocket.Dialer{NetDial: dial}
+		wsConn, _, err := dialer.Dial(getURL(server, protocol), nil)
+		require.NoError(t, err)
+		conn = NewWebSocketConn(wsConn)
+	} else {
+		netConn, err := dial("tcp", server.Addr().String())
+		require.NoError(t, err)
+		conn = NewNetConn(netConn)
+	}
+
+	err = conn.Send(packet.NewConnectPacket())
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	err = conn.Close()
+	assert.NoError(t, err)
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
+
+func TestProxyNetServer(t *testing.T) {
+	abstractProxyServerTest(t, "tcp", []byte("PROXY TCP4 10.0.0.1 10.0.0.2 5000 1883\r\n"), "10.0.0.1:5000")
+	abstractProxyServerTest(t, "tcp", proxyHeaderV2(nil), "10.0.0.1:5000")
+}
+
+func TestProxyWebSocketServer(t *testing.T) {
+	abstractProxyServerTest(t, "ws", []byte("PROXY TCP4 10.0.0.1 10.0.0.2 5000 1883\r\n"), "10.0.0.1:5000")
+	abstractProxyServerTest(t, "ws", proxyHeaderV2(nil), "10.0.0.1:5000")
+}
+
+func TestProxyServerUntrustedSource(t *testing.T) {
+	server, err := NewProxyNetServer("localhost:0", &ProxyConfig{
+		TrustedSources: []string{"10.0.0.0/8"},
+		Required:       true,
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		conn, err := server.Accept()
+		require.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.Equal(t, packet.CONNECT, pkt.Type())
+		assert.NoError(t, err)
+
+		assert.Nil(t, conn.(*NetConn).ProxyHeader())
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+
+		close(done)
+	}()
+
+	conn, err := Dial("tcp://" + server.Addr().String())
+	require.NoError(t, err)
+
+	err = conn.Send(packet.NewConnectPacket())
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	err = conn.Close()
+	assert.NoError(t, err)
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
+
+func TestProxyServerRequiredHeader(t *testing.T) {
+	server, err := NewProxyNetServer("localhost:0", &ProxyConfig{
+		Required: true,
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		conn, err := server.Accept()
+		require.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.Nil(t, pkt)
+		assert.Equal(t, ErrMissingProxyHeader, err)
+
+		close(done)
+	}()
+
+	conn, err := Dial("tcp://" + server.Addr().String())
+	require.NoError(t, err)
+
+	err = conn.Send(packet.NewConnectPacket())
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
+
+func TestProxyServerHeaderTimeout(t *testing.T) {
+	server, err := NewProxyNetServer("localhost:0", &ProxyConfig{
+		HeaderTimeout: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		conn, err := server.Accept()
+		require.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.Nil(t, pkt)
+		assert.Error(t, err)
+
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+
+	safeReceive(done)
+
+	err = conn.Close()
+	assert.NoError(t, err)
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
+
+func TestProxyServerInvalidTrustedSource(t *testing.T) {
+	server, err := NewProxyNetServer("localhost:0", &ProxyConfig{
+		TrustedSources: []string{"foo"},
+	})
+	assert.Error(t, err)
+	assert.Nil(t, server)
+}
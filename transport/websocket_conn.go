@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -120,7 +121,19 @@ func (c *WebSocketConn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// TLSConnectionState returns the state of the underlying TLS connection and
+// true, or false if the connection is not secured by TLS.
+func (c *WebSocketConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return tlsConnectionState(c.conn.UnderlyingConn())
+}
+
 // UnderlyingConn returns the underlying websocket.Conn.
 func (c *WebSocketConn) UnderlyingConn() *websocket.Conn {
 	return c.conn
 }
+
+// ProxyHeader returns the PROXY protocol header sent by a load balancer or nil
+// if the connection has not been accepted by a proxy server.
+func (c *WebSocketConn) ProxyHeader() *ProxyHeader {
+	return proxyHeader(c.conn.UnderlyingConn())
+}
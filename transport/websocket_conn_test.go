@@ -53,6 +53,11 @@ func TestWebSocketConnAddr(t *testing.T) {
 	abstractConnAddrTest(t, "ws")
 }
 
+func TestWebSocketConnTLSConnectionState(t *testing.T) {
+	abstractConnTLSConnectionStateTest(t, "ws", false)
+	abstractConnTLSConnectionStateTest(t, "wss", true)
+}
+
 func TestWebSocketConnBufferedSend(t *testing.T) {
 	abstractConnBufferedSendTest(t, "ws")
 }
@@ -74,6 +74,34 @@ func NewSecureWebSocketServer(address string, config *tls.Config) (*WebSocketSer
 	return s, nil
 }
 
+// NewProxyWebSocketServer creates a new WS server that listens on the provided
+// address and parses PROXY protocol headers using the provided config.
+func NewProxyWebSocketServer(address string, proxyConfig *ProxyConfig) (*WebSocketServer, error) {
+	listener, err := listenProxy(address, proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newWebSocketServer(listener)
+	s.serveHTTP()
+
+	return s, nil
+}
+
+// NewSecureProxyWebSocketServer creates a new WSS server that listens on the
+// provided address and parses PROXY protocol headers using the provided config.
+func NewSecureProxyWebSocketServer(address string, config *tls.Config, proxyConfig *ProxyConfig) (*WebSocketServer, error) {
+	listener, err := listenProxy(address, proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newWebSocketServer(tls.NewListener(listener, config))
+	s.serveHTTP()
+
+	return s, nil
+}
+
 func (s *WebSocketServer) serveHTTP() {
 	s.mux = http.NewServeMux()
 	s.mux.HandleFunc("/", s.requestHandler)